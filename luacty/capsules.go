@@ -0,0 +1,86 @@
+package luacty
+
+import (
+	"fmt"
+	"reflect"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// RegisterCapsuleType associates the given cty capsule type with a Lua
+// metatable, so that values of that type behave as proper Lua objects.
+//
+// Once registered, WrapCtyValue returns known, non-null and unmarked values
+// of the capsule type as userdata whose Value is the encapsulated Go value
+// (a pointer to a value of the type's native type) and whose metatable is the
+// one given here. The metatable is then entirely responsible for the
+// behavior of the value in Lua, such as its methods via __index, its string
+// representation via __tostring and its equality via __eq.
+//
+// Conversely, userdata that has the given metatable converts back to a
+// value of the capsule type encapsulating its Value, so Lua functions in
+// the application can create new objects of that type by setting the
+// metatable on a new userdata. Its Value must be a pointer to a value of the
+// capsule type's native type, or conversion fails with an error.
+//
+// Unknown, null and marked values of the capsule type are still wrapped in
+// the usual way, since there is no Go value for the metatable to work with.
+//
+// RegisterCapsuleType panics if the given type is not a capsule type or if
+// either the type or the metatable is already registered.
+func (c *Converter) RegisterCapsuleType(ty cty.Type, metatable *lua.LTable) {
+	if !ty.IsCapsuleType() {
+		panic(fmt.Sprintf("RegisterCapsuleType with non-capsule type %#v", ty))
+	}
+	if _, exists := c.capsuleMetatables[ty]; exists {
+		panic(fmt.Sprintf("capsule type %#v is already registered", ty))
+	}
+	if _, exists := c.capsuleTypes[metatable]; exists {
+		panic("metatable is already registered for another capsule type")
+	}
+	if c.capsuleMetatables == nil {
+		c.capsuleMetatables = make(map[cty.Type]*lua.LTable)
+		c.capsuleTypes = make(map[*lua.LTable]cty.Type)
+	}
+	c.capsuleMetatables[ty] = metatable
+	c.capsuleTypes[metatable] = ty
+}
+
+// wrapCapsule returns the userdata representing the given value using a
+// metatable registered with RegisterCapsuleType, or nil if the value cannot
+// be represented in that way.
+func (c *Converter) wrapCapsule(val cty.Value) *lua.LUserData {
+	if !val.Type().IsCapsuleType() || !val.IsKnown() || val.IsNull() || val.IsMarked() {
+		return nil
+	}
+	metatable, registered := c.capsuleMetatables[val.Type()]
+	if !registered {
+		return nil
+	}
+	ret := c.lstate.NewUserData()
+	ret.Value = val.EncapsulatedValue()
+	ret.Metatable = metatable
+	return ret
+}
+
+// capsuleType returns the capsule type registered for the metatable of the
+// given userdata, if any.
+func (c *Converter) capsuleType(ud *lua.LUserData) (cty.Type, bool) {
+	metatable, isTable := ud.Metatable.(*lua.LTable)
+	if !isTable {
+		return cty.NilType, false
+	}
+	ty, registered := c.capsuleTypes[metatable]
+	return ty, registered
+}
+
+// capsuleVal returns the value of the given capsule type represented by the
+// given userdata, which must have the metatable registered for that type.
+func capsuleVal(ty cty.Type, ud *lua.LUserData, path cty.Path) (cty.Value, error) {
+	want := reflect.PtrTo(ty.EncapsulatedType())
+	if ud.Value == nil || reflect.TypeOf(ud.Value) != want {
+		return cty.DynamicVal, path.NewErrorf("userdata for %s must contain a %s", ty.FriendlyName(), want)
+	}
+	return cty.CapsuleVal(ty, ud.Value), nil
+}
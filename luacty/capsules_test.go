@@ -0,0 +1,128 @@
+package luacty
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+type testPoint struct {
+	X, Y int
+}
+
+var testPointType = cty.Capsule("point", reflect.TypeOf(testPoint{}))
+
+func testPointMetatable(L *lua.LState) *lua.LTable {
+	checkPoint := func(L *lua.LState, n int) *testPoint {
+		ud := L.CheckUserData(n)
+		p, ok := ud.Value.(*testPoint)
+		if !ok {
+			L.ArgError(n, "point expected")
+		}
+		return p
+	}
+
+	methods := L.NewTable()
+	L.SetFuncs(methods, map[string]lua.LGFunction{
+		"x": func(L *lua.LState) int {
+			L.Push(lua.LNumber(checkPoint(L, 1).X))
+			return 1
+		},
+	})
+	mt := L.NewTable()
+	mt.RawSetString("__index", methods)
+	L.SetFuncs(mt, map[string]lua.LGFunction{
+		"__tostring": func(L *lua.LState) int {
+			p := checkPoint(L, 1)
+			L.Push(lua.LString(fmt.Sprintf("(%d, %d)", p.X, p.Y)))
+			return 1
+		},
+		"__eq": func(L *lua.LState) int {
+			L.Push(lua.LBool(*checkPoint(L, 1) == *checkPoint(L, 2)))
+			return 1
+		},
+	})
+	return mt
+}
+
+func TestConverterRegisterCapsuleType(t *testing.T) {
+	L := lua.NewState()
+	conv := NewConverter(L)
+	mt := testPointMetatable(L)
+	conv.RegisterCapsuleType(testPointType, mt)
+	L.SetGlobal("point", L.NewFunction(func(L *lua.LState) int {
+		ud := L.NewUserData()
+		ud.Value = &testPoint{X: L.CheckInt(1), Y: L.CheckInt(2)}
+		ud.Metatable = mt
+		L.Push(ud)
+		return 1
+	}))
+
+	p := &testPoint{X: 1, Y: 2}
+	L.SetGlobal("p", conv.WrapCtyValue(cty.CapsuleVal(testPointType, p)))
+	L.SetGlobal("obj", conv.WrapCtyValue(cty.ObjectVal(map[string]cty.Value{
+		"p": cty.CapsuleVal(testPointType, p),
+	})))
+
+	err := L.DoString(`
+		assert(p:x() == 1, "wrong x")
+		assert(tostring(p) == "(1, 2)", "wrong string " .. tostring(p))
+		assert(p == point(1, 2), "not equal")
+		assert(obj.p:x() == 1, "wrong x from attribute")
+		result = point(3, 4)
+	`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ty, err := conv.ImpliedCtyType(L.GetGlobal("result"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !ty.Equals(testPointType) {
+		t.Errorf("wrong type %#v; want %#v", ty, testPointType)
+	}
+	got, err := conv.ToCtyValue(L.GetGlobal("result"), cty.DynamicPseudoType)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := (testPoint{X: 3, Y: 4}); *(got.EncapsulatedValue().(*testPoint)) != want {
+		t.Errorf("wrong result %#v; want %#v", got.EncapsulatedValue(), want)
+	}
+
+	// The same Go value passes back out unchanged.
+	got, err = conv.ToCtyValue(L.GetGlobal("p"), testPointType)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got.EncapsulatedValue().(*testPoint) != p {
+		t.Errorf("wrong result %p; want %p", got.EncapsulatedValue(), p)
+	}
+
+	// Unknown values are still wrapped in the usual way.
+	unk := conv.WrapCtyValue(cty.UnknownVal(testPointType)).(*lua.LUserData)
+	if unk.Metatable == mt {
+		t.Errorf("unknown value has the capsule metatable")
+	}
+}
+
+func TestConverterRegisterCapsuleTypeWrongValue(t *testing.T) {
+	L := lua.NewState()
+	conv := NewConverter(L)
+	mt := testPointMetatable(L)
+	conv.RegisterCapsuleType(testPointType, mt)
+
+	ud := L.NewUserData()
+	ud.Value = "not a point"
+	ud.Metatable = mt
+	_, err := conv.ToCtyValue(ud, cty.DynamicPseudoType)
+	if err == nil {
+		t.Fatalf("conversion succeeded; want error")
+	}
+	if got, want := err.Error(), "userdata for point must contain a *luacty.testPoint"; got != want {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
@@ -2,6 +2,7 @@ package luacty
 
 import (
 	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
 )
 
 // Converter is the main type in this package, providing the conversion
@@ -10,15 +11,140 @@ import (
 // A converter is specific to a givan lua.LState because it uses that state
 // to create new values and to interact with the Lua stack during operations.
 type Converter struct {
-	lstate    *lua.LState
-	metatable *lua.LTable
+	lstate           *lua.LState
+	metatable        *lua.LTable
+	methods          *lua.LTable
+	methodsMetatable *lua.LTable
+	typeMetatable    *lua.LTable
+	errorMetatable   *lua.LTable
+	arrayMetatable   *lua.LTable
+	dictMetatable    *lua.LTable
+
+	capsuleMetatables map[cty.Type]*lua.LTable
+	capsuleTypes      map[*lua.LTable]cty.Type
+
+	nativePrimitives bool
+	exactNumbers     bool
+	stringPolicy     StringPolicy
+	luaValues        bool
+	markPolicy       MarkPolicy
+	sequencesAsLists bool
+	emptyTableType   cty.Type
+	maxDepth         int
 }
 
+// ConverterOption is the type of the optional arguments to NewConverter,
+// which each customize some aspect of the converter's behavior.
+type ConverterOption func(c *Converter)
+
 // NewConverter creates and returns a new Converter for the given Lua state.
-func NewConverter(L *lua.LState) *Converter {
+//
+// The default behavior of the converter can be customized by passing
+// additional options, such as WithNativePrimitives.
+func NewConverter(L *lua.LState, opts ...ConverterOption) *Converter {
 	c := &Converter{
-		lstate: L,
+		lstate:         L,
+		emptyTableType: cty.EmptyObject,
+		maxDepth:       DefaultMaxDepth,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.methods = c.ctyMethods()
+	c.methodsMetatable = c.ctyMethodsMetatable()
 	c.metatable = c.ctyMetatable()
+	c.typeMetatable = c.ctyTypeMetatable()
+	c.errorMetatable = c.ctyErrorMetatable()
+
+	// The array and dict metatables have no behavior of their own; they
+	// just tag tables with how they should be interpreted by ImpliedCtyType.
+	c.arrayMetatable = L.NewTable()
+	c.dictMetatable = L.NewTable()
 	return c
 }
+
+// WithNativePrimitives is a ConverterOption that causes values produced by
+// operations on wrapped values to be returned as native Lua values where
+// possible, as would be returned by WrapCtyValueNative.
+//
+// This affects the results of indexing, arithmetic and other operators,
+// methods and wrapped cty functions, as well as the arguments passed to Lua
+// functions that are called as cty functions. It does not affect the
+// result of WrapCtyValue itself.
+func WithNativePrimitives() ConverterOption {
+	return func(c *Converter) {
+		c.nativePrimitives = true
+	}
+}
+
+// WithExactNumbers is a ConverterOption that avoids losing precision when
+// numbers cross between Lua and cty.
+//
+// Lua numbers are 64-bit floating point values, and so they cannot exactly
+// represent large integers such as account IDs, whereas cty numbers have
+// arbitrary precision. With this option, a Lua number that is an integer
+// converts to a cty number with enough precision that later arithmetic on
+// it remains exact, rather than being rounded to the precision of a Lua
+// number. Scripts can construct numbers too large for a Lua number from
+// strings, either using cty.number("12345678901234567890") from the Lua
+// module or by giving the string directly to an operation on a wrapped
+// number.
+//
+// ToLuaValue and the tonative method also return an error for a number
+// that cannot be represented exactly as a Lua number, rather than silently
+// rounding it. WrapCtyValueNative never rounds numbers, regardless of this
+// option.
+func WithExactNumbers() ConverterOption {
+	return func(c *Converter) {
+		c.exactNumbers = true
+	}
+}
+
+// WithSequencesAsLists is a ConverterOption that causes Lua tables that are
+// sequences to imply list types rather than tuple types, when the types of
+// their elements can be unified into a single type.
+//
+// This affects the result of ImpliedCtyType and of ToCtyValue when given
+// cty.DynamicPseudoType as the target type.
+func WithSequencesAsLists() ConverterOption {
+	return func(c *Converter) {
+		c.sequencesAsLists = true
+	}
+}
+
+// WithEmptyTableType is a ConverterOption that selects the type implied by
+// an empty Lua table, which is otherwise ambiguous. The default is
+// cty.EmptyObject, but cty.EmptyTuple or an empty collection type such as
+// cty.List(cty.DynamicPseudoType) may be more appropriate for applications
+// that expect sequences.
+//
+// Scripts can also resolve the ambiguity for individual tables using the
+// array and dict functions in the Lua module, which take priority over
+// this option.
+//
+// This affects the result of ImpliedCtyType and of ToCtyValue when given
+// cty.DynamicPseudoType as the target type.
+func WithEmptyTableType(ty cty.Type) ConverterOption {
+	return func(c *Converter) {
+		c.emptyTableType = ty
+	}
+}
+
+// DefaultMaxDepth is the default limit on how deeply Lua tables may be
+// nested when converting them to cty values with automatically-chosen
+// types, which can be changed using WithMaxDepth.
+const DefaultMaxDepth = 100
+
+// WithMaxDepth is a ConverterOption that sets the limit on how deeply Lua
+// tables may be nested when a conversion must choose a type automatically,
+// such as in ImpliedCtyType. Tables nested more deeply produce an error,
+// rather than risking exhausting the Go stack. A limit of zero or less
+// disables the check.
+//
+// Tables that refer to themselves are always rejected, regardless of this
+// setting.
+func WithMaxDepth(depth int) ConverterOption {
+	return func(c *Converter) {
+		c.maxDepth = depth
+	}
+}
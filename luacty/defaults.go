@@ -0,0 +1,155 @@
+package luacty
+
+import (
+	"strconv"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+)
+
+// Defaults describes default values for the optional attributes of an
+// object type, and of any object types nested inside it.
+//
+// It has the same structure as the Defaults type in HCL's typeexpr package,
+// so applications that already build those can easily build these too.
+type Defaults struct {
+	// Type is the type that these defaults apply to. It must be an object
+	// type if DefaultValues is not empty, and may be left unset otherwise.
+	Type cty.Type
+
+	// DefaultValues are the default values for the optional attributes of
+	// Type, keyed by attribute name. A default value is used only for an
+	// attribute that Type declares as optional, so a default never replaces
+	// a null value of a required attribute.
+	DefaultValues map[string]cty.Value
+
+	// Children are the defaults for the types nested inside Type. For an
+	// object type the keys are attribute names, for a tuple type they are
+	// element indices as decimal strings, and for a collection type the
+	// only key is the empty string, for the element type.
+	Children map[string]*Defaults
+}
+
+// ToCtyValueWithDefaults is like ToCtyValue except that after conversion it
+// substitutes the given default values for any optional attributes that
+// were not set, so that scripts can return partial tables and the caller
+// receives fully-populated objects.
+//
+// Defaults are converted to the type of the attribute they apply to, and
+// the result is an error if that isn't possible. A nil defaults is allowed
+// and makes this method equivalent to ToCtyValue.
+//
+// This is a shorthand for ToCtyValueWithOptions with only the Defaults field
+// of the options set.
+func (c *Converter) ToCtyValueWithDefaults(val lua.LValue, ty cty.Type, defaults *Defaults) (cty.Value, error) {
+	return c.ToCtyValueWithOptions(val, ty, &ToCtyOptions{
+		Defaults: defaults,
+	})
+}
+
+func (d *Defaults) apply(val cty.Value, path cty.Path) (cty.Value, error) {
+	if d == nil || val.IsNull() || !val.IsKnown() {
+		return val, nil
+	}
+
+	val, marks := val.Unmark()
+	ty := val.Type()
+	switch {
+	case ty.IsObjectType():
+		if len(d.DefaultValues) > 0 && !d.Type.IsObjectType() {
+			return cty.DynamicVal, path.NewErrorf("invalid defaults: default values require an object type")
+		}
+		attrs := val.AsValueMap()
+		if attrs == nil {
+			attrs = make(map[string]cty.Value)
+		}
+		for name, dv := range d.DefaultValues {
+			if !ty.HasAttribute(name) || !attrs[name].IsNull() {
+				continue
+			}
+			if !d.Type.HasAttribute(name) || !d.Type.AttributeOptional(name) {
+				continue
+			}
+			path := append(path, cty.GetAttrStep{Name: name})
+			av, err := convert.Convert(dv, ty.AttributeType(name))
+			if err != nil {
+				return cty.DynamicVal, path.NewErrorf("invalid default value: %s", err)
+			}
+			attrs[name] = av
+		}
+		for name, child := range d.Children {
+			if !ty.HasAttribute(name) {
+				continue
+			}
+			path := append(path, cty.GetAttrStep{Name: name})
+			av, err := child.apply(attrs[name], path)
+			if err != nil {
+				return cty.DynamicVal, err
+			}
+			attrs[name] = av
+		}
+		return cty.ObjectVal(attrs).WithMarks(marks), nil
+
+	case ty.IsTupleType():
+		elems := val.AsValueSlice()
+		for i := range elems {
+			child := d.Children[strconv.Itoa(i)]
+			if child == nil {
+				continue
+			}
+			path := append(path, cty.IndexStep{Key: cty.NumberIntVal(int64(i))})
+			ev, err := child.apply(elems[i], path)
+			if err != nil {
+				return cty.DynamicVal, err
+			}
+			elems[i] = ev
+		}
+		if len(elems) == 0 {
+			return val.WithMarks(marks), nil
+		}
+		return cty.TupleVal(elems).WithMarks(marks), nil
+
+	case ty.IsCollectionType():
+		child := d.Children[""]
+		if child == nil || val.LengthInt() == 0 {
+			return val.WithMarks(marks), nil
+		}
+		switch {
+		case ty.IsMapType():
+			elems := val.AsValueMap()
+			for k, ev := range elems {
+				path := append(path, cty.IndexStep{Key: cty.StringVal(k)})
+				ev, err := child.apply(ev, path)
+				if err != nil {
+					return cty.DynamicVal, err
+				}
+				elems[k] = ev
+			}
+			return cty.MapVal(elems).WithMarks(marks), nil
+		default:
+			elems := val.AsValueSlice()
+			for i, ev := range elems {
+				var key cty.Value
+				if ty.IsSetType() {
+					key = ev
+				} else {
+					key = cty.NumberIntVal(int64(i))
+				}
+				path := append(path, cty.IndexStep{Key: key})
+				ev, err := child.apply(ev, path)
+				if err != nil {
+					return cty.DynamicVal, err
+				}
+				elems[i] = ev
+			}
+			if ty.IsSetType() {
+				return cty.SetVal(elems).WithMarks(marks), nil
+			}
+			return cty.ListVal(elems).WithMarks(marks), nil
+		}
+
+	default:
+		return val.WithMarks(marks), nil
+	}
+}
@@ -0,0 +1,212 @@
+package luacty
+
+import (
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestConverterToCtyValueWithDefaults(t *testing.T) {
+	serverType := cty.ObjectWithOptionalAttrs(map[string]cty.Type{
+		"host": cty.String,
+		"port": cty.Number,
+		"tls":  cty.Bool,
+	}, []string{"port", "tls"})
+	serverDefaults := &Defaults{
+		Type: serverType,
+		DefaultValues: map[string]cty.Value{
+			"port": cty.NumberIntVal(8080),
+			"tls":  cty.False,
+		},
+	}
+	serverObj := func(host string, port int64, tls bool) cty.Value {
+		return cty.ObjectVal(map[string]cty.Value{
+			"host": cty.StringVal(host),
+			"port": cty.NumberIntVal(port),
+			"tls":  cty.BoolVal(tls),
+		})
+	}
+
+	tests := map[string]struct {
+		Src      string
+		Type     cty.Type
+		Defaults *Defaults
+		Want     cty.Value
+		Err      bool
+	}{
+		"object": {
+			`{host = "a"}`,
+			serverType,
+			serverDefaults,
+			serverObj("a", 8080, false),
+			false,
+		},
+		"object with values set": {
+			`{host = "a", port = 443, tls = true}`,
+			serverType,
+			serverDefaults,
+			serverObj("a", 443, true),
+			false,
+		},
+		"nested": {
+			`{servers = {{host = "a"}, {host = "b", tls = true}}, meta = {x = {host = "c"}}}`,
+			cty.Object(map[string]cty.Type{
+				"servers": cty.List(serverType),
+				"meta":    cty.Map(serverType),
+			}),
+			&Defaults{
+				Children: map[string]*Defaults{
+					"servers": {
+						Children: map[string]*Defaults{
+							"": serverDefaults,
+						},
+					},
+					"meta": {
+						Children: map[string]*Defaults{
+							"": serverDefaults,
+						},
+					},
+				},
+			},
+			cty.ObjectVal(map[string]cty.Value{
+				"servers": cty.ListVal([]cty.Value{
+					serverObj("a", 8080, false),
+					serverObj("b", 8080, true),
+				}),
+				"meta": cty.MapVal(map[string]cty.Value{
+					"x": serverObj("c", 8080, false),
+				}),
+			}),
+			false,
+		},
+		"tuple": {
+			`{{host = "a"}, "b"}`,
+			cty.Tuple([]cty.Type{serverType, cty.String}),
+			&Defaults{
+				Children: map[string]*Defaults{
+					"0": serverDefaults,
+				},
+			},
+			cty.TupleVal([]cty.Value{
+				serverObj("a", 8080, false),
+				cty.StringVal("b"),
+			}),
+			false,
+		},
+		"nil defaults": {
+			`{host = "a"}`,
+			serverType,
+			nil,
+			cty.ObjectVal(map[string]cty.Value{
+				"host": cty.StringVal("a"),
+				"port": cty.NullVal(cty.Number),
+				"tls":  cty.NullVal(cty.Bool),
+			}),
+			false,
+		},
+		"missing required attribute": {
+			`{port = 1}`,
+			serverType,
+			serverDefaults,
+			cty.DynamicVal,
+			true, // attribute "host" is required
+		},
+		"required attribute": {
+			`{host = "a"}`,
+			cty.Object(map[string]cty.Type{
+				"host": cty.String,
+				"port": cty.Number,
+			}),
+			&Defaults{
+				Type: cty.Object(map[string]cty.Type{
+					"host": cty.String,
+					"port": cty.Number,
+				}),
+				DefaultValues: map[string]cty.Value{
+					"port": cty.NumberIntVal(8080),
+				},
+			},
+			cty.ObjectVal(map[string]cty.Value{
+				"host": cty.StringVal("a"),
+				"port": cty.NullVal(cty.Number),
+			}),
+			false,
+		},
+		"defaults without type": {
+			`{host = "a"}`,
+			serverType,
+			&Defaults{
+				DefaultValues: map[string]cty.Value{
+					"port": cty.NumberIntVal(8080),
+				},
+			},
+			cty.DynamicVal,
+			true, // invalid defaults: default values require an object type
+		},
+		"invalid default": {
+			`{host = "a"}`,
+			serverType,
+			&Defaults{
+				Type: serverType,
+				DefaultValues: map[string]cty.Value{
+					"port": cty.StringVal("not a number"),
+				},
+			},
+			cty.DynamicVal,
+			true, // invalid default value: a number is required
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L)
+			if err := L.DoString("v = " + test.Src); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			got, err := conv.ToCtyValueWithDefaults(L.GetGlobal("v"), test.Type, test.Defaults)
+			if (err != nil) != test.Err {
+				if test.Err {
+					t.Errorf("conversion succeeded; want error")
+				} else {
+					t.Errorf("unexpected error: %s", err)
+				}
+			}
+			if !got.RawEquals(test.Want) {
+				t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, test.Want)
+			}
+		})
+	}
+}
+
+func TestConverterToCtyValueAllErrorsWithDefaults(t *testing.T) {
+	L := lua.NewState()
+	conv := NewConverter(L)
+	ty := cty.ObjectWithOptionalAttrs(map[string]cty.Type{
+		"host": cty.String,
+		"port": cty.Number,
+	}, []string{"port"})
+	defaults := &Defaults{
+		Type: ty,
+		DefaultValues: map[string]cty.Value{
+			"port": cty.NumberIntVal(8080),
+		},
+	}
+	if err := L.DoString(`v = {host = "a"}`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got, errs := conv.ToCtyValueAllErrors(L.GetGlobal("v"), ty, &ToCtyOptions{Defaults: defaults})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	want := cty.ObjectVal(map[string]cty.Value{
+		"host": cty.StringVal("a"),
+		"port": cty.NumberIntVal(8080),
+	})
+	if !got.RawEquals(want) {
+		t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, want)
+	}
+}
@@ -22,6 +22,39 @@
 // cty lists and tuples retain their zero-indexing rather than adopting the
 // one-indexing that Lua uses for its own indexed tables.
 //
+// Wrapping of primitive values is the most surprising of these tradeoffs,
+// since a wrapped cty.False is a userdata value and so Lua considers it to
+// be "true" in conditionals. Applications can instead select native Lua
+// values for known primitive values, either for individual values using
+// WrapCtyValueNative or for all results of operations on wrapped values by
+// passing WithNativePrimitives to NewConverter. For situations where a Lua
+// program needs plain Lua tables, such as when passing data to Lua libraries
+// that are not aware of cty, ToLuaValue produces a deep copy of a cty value
+// using only native Lua values.
+//
+// Lua's comparison operators must always produce a Lua boolean, so they
+// treat an unknown result as false. Scripts that may encounter unknown
+// values should test for them explicitly with the is_known method before
+// comparing.
+//
+// Operations on wrapped values preserve any cty marks on their operands,
+// such as a mark indicating that a value is sensitive, so the result of
+// concatenating a marked string is also marked. Comparisons are again the
+// exception, since a Lua boolean cannot carry marks. Scripts can inspect
+// and change marks as permitted by the converter's MarkPolicy.
+//
+// Failed operations on wrapped values raise error objects rather than
+// strings, so that scripts using pcall can inspect what went wrong. See
+// OperationError for the fields these objects expose. Because GopherLua
+// does not know how to describe these objects, the error returned by a
+// method such as DoString describes itself only as "userdata: 0x...", and
+// so Go callers should pass such errors through UnwrapOperationError before
+// reporting them:
+//
+//     if err := L.DoString(src); err != nil {
+//         return luacty.UnwrapOperationError(err)
+//     }
+//
 // Conversion of Lua values out to cty is done by actual conversion rather
 // than wrapping, producing new cty values that start with equivalent content
 // to the given Lua value but using cty semantics rather than Lua semantics.
@@ -33,7 +66,7 @@
 //     LBool       cty.Bool
 //     LNumber     cty.Number
 //     LString     cty.String
-//     LTable      cty.Object
+//     LTable      cty.Object, or cty.Tuple for a sequence
 //     LUserData   cty.Value (when userdata was created by this package)
 //
 // When cty type information can be provided for conversion, additional
@@ -41,8 +74,16 @@
 // cty.Map and cty.Tuple where the table content meets the constraints of
 // these types.
 //
+// Lua scripts can also construct cty values directly, using the functions
+// in the Lua module registered by Converter.PreloadModule.
+//
 // No value conversions are available for Lua functions or userdata that
-// was created by other packages. A wrapper is provided to allow Lua functions
-// to be used as cty functions within applications that make use
-// of the cty function extension, but cty functions are not cty values.
+// was created by other packages, but such values can be carried through cty
+// structures unchanged using the capsule type LuaValueType. Applications
+// can also register their own capsule types with a Lua metatable using
+// Converter.RegisterCapsuleType, so that userdata with that metatable
+// converts to values of the capsule type and back. A wrapper is
+// provided to allow Lua functions to be used as cty functions within
+// applications that make use of the cty function extension, but cty
+// functions are not cty values.
 package luacty
@@ -0,0 +1,462 @@
+package luacty
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// LuaError is the error type returned when Lua code called via a function
+// produced by ToCtyFunction raises an error with a string message, such as
+// by calling Lua's "error" function with a string argument.
+type LuaError struct {
+	// Message is the error message, without any source position prefix.
+	Message string
+
+	// File and Line give the source position where the error was raised,
+	// if known. File is the chunk name given when the Lua code was
+	// loaded, and is empty if the position is not known.
+	File string
+	Line int
+
+	// Stack is a Lua stack traceback for the error, if available.
+	Stack string
+}
+
+func (e *LuaError) Error() string {
+	if e.File == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+}
+
+// AsLuaError returns a LuaError describing the given error, if it is either
+// a LuaError itself or a *lua.ApiError produced by Lua code raising an error
+// with a string message, such as an error returned from DoString.
+func AsLuaError(err error) (*LuaError, bool) {
+	var luaErr *LuaError
+	if errors.As(err, &luaErr) {
+		return luaErr, true
+	}
+	apiErr, isAPI := err.(*lua.ApiError)
+	if !isAPI || apiErr.Type != lua.ApiErrorRun {
+		return nil, false
+	}
+	msg, isStr := apiErr.Object.(lua.LString)
+	if !isStr {
+		return nil, false
+	}
+	return newLuaError(string(msg), apiErr.StackTrace), true
+}
+
+// newLuaError creates a LuaError from the given message, splitting off the
+// source position prefix that Lua adds to messages, if present.
+func newLuaError(msg string, stack string) *LuaError {
+	ret := &LuaError{
+		Message: msg,
+		Stack:   stack,
+	}
+	if match := luaErrorPosition.FindStringSubmatch(msg); match != nil {
+		ret.File = match[1]
+		ret.Line, _ = strconv.Atoi(match[2])
+		ret.Message = match[3]
+	}
+	return ret
+}
+
+// luaErrorPosition matches the source position prefix that Lua adds to
+// string error messages.
+var luaErrorPosition = regexp.MustCompile(`(?s)^([^\n]+?):(\d+): (.*)$`)
+
+// functionError translates an error returned from calling a Lua function
+// into an error suitable to return from a cty function implementation.
+//
+// If the Lua code raised an error using a table, the table's "msg" field
+// is the error message. If the table also has an "arg" field then the
+// result is a function.ArgError for that argument, counting from one as
+// Lua does. If the table has a "path" field containing a sequence of
+// attribute names (strings) and zero-based indices (numbers) then the
+// result is, or the ArgError wraps, a cty.PathError with that path. Use
+// errors.As to find both, since the result is then not itself an ArgError.
+//
+// If the Lua code raised an OperationError, such as by performing an
+// invalid operation on a wrapped value, the result is that OperationError.
+// Other errors become LuaError values, or are returned verbatim if they
+// didn't come from Lua code at all.
+//
+// nArgs is the number of arguments passed to the function, which is used
+// to ignore argument indices that are out of range.
+func (c *Converter) functionError(err error, nArgs int) error {
+	apiErr, isAPI := err.(*lua.ApiError)
+	if !isAPI {
+		return err
+	}
+
+	if opErr, isOp := AsOperationError(err); isOp {
+		return opErr
+	}
+
+	switch obj := apiErr.Object.(type) {
+	case *lua.LTable:
+		return c.functionErrorFromTable(obj, apiErr, nArgs)
+	case lua.LString:
+		return newLuaError(string(obj), apiErr.StackTrace)
+	default:
+		return &LuaError{
+			Message: fmt.Sprintf("(error object is a %s value)", apiErr.Object.Type().String()),
+			Stack:   apiErr.StackTrace,
+		}
+	}
+}
+
+func (c *Converter) functionErrorFromTable(table *lua.LTable, apiErr *lua.ApiError, nArgs int) error {
+	msgL := table.RawGetString("msg")
+	if msgL == lua.LNil {
+		msgL = table.RawGetString("message")
+	}
+	if !lua.LVCanConvToString(msgL) {
+		return &LuaError{
+			Message: "(error object is a table value)",
+			Stack:   apiErr.StackTrace,
+		}
+	}
+	msg := lua.LVAsString(msgL)
+
+	ret := errors.New(msg)
+	var pathErr error
+	if pathL, isTable := table.RawGetString("path").(*lua.LTable); isTable {
+		path, err := luaPath(pathL)
+		if err == nil {
+			ret = path.NewError(ret)
+			pathErr = ret
+		}
+	}
+	if argL, isNum := table.RawGetString("arg").(lua.LNumber); isNum {
+		idx := int(argL) - 1 // Lua arguments are counted from one
+		if float64(idx+1) == float64(argL) && idx >= 0 && idx < nArgs {
+			ret = function.NewArgError(idx, ret)
+			if pathErr != nil {
+				ret = argPathError{ret.(function.ArgError), pathErr}
+			}
+		}
+	}
+	return ret
+}
+
+// argPathError is a function.ArgError whose error is a cty.PathError.
+//
+// function.ArgError doesn't implement Unwrap, so callers couldn't otherwise
+// find the path using errors.As. argPathError unwraps to both errors.
+type argPathError struct {
+	function.ArgError
+	pathErr error
+}
+
+func (e argPathError) Unwrap() []error {
+	return []error{e.ArgError, e.pathErr}
+}
+
+// luaPath converts a Lua sequence of attribute names and indices into a
+// cty.Path.
+func luaPath(table *lua.LTable) (cty.Path, error) {
+	l := table.Len()
+	path := make(cty.Path, l)
+	for i := range path {
+		switch step := table.RawGetInt(i + 1).(type) {
+		case lua.LString:
+			path[i] = cty.GetAttrStep{Name: string(step)}
+		case lua.LNumber:
+			path[i] = cty.IndexStep{Key: cty.NumberFloatVal(float64(step))}
+		default:
+			return nil, fmt.Errorf("path steps must be strings or numbers")
+		}
+	}
+	return path, nil
+}
+
+// OperationError is the error type raised as a Lua error object when an
+// operation on a wrapped value fails, such as arithmetic, concatenation,
+// indexing or a call to a function produced by WrapCtyFunction.
+//
+// In Lua, the error object has the following fields, and converts to a
+// string using the result of the Error method:
+//
+//   - "message" is the message of the underlying error.
+//   - "kind" is one of the strings "conversion" (an operand could not be
+//     converted to the type the operation requires), "argument" (an
+//     argument to a function was invalid), "index" (an index or key was
+//     invalid), "call" (a function returned an error) or "operation" (any
+//     other failure).
+//   - "path" is a sequence of attribute names and zero-based indices
+//     identifying the part of a value that the error relates to, or nil
+//     if the error does not relate to a specific part of a value.
+//   - "arg" is the index of the function argument that the error relates
+//     to, counting from one, or nil if the error does not relate to a
+//     specific argument.
+//
+// GopherLua describes an error object using its generic string
+// representation, so the Error method of the *lua.ApiError returned by a
+// lua.LState method such as DoString or PCall produces only something like
+// "userdata: 0xc000123456" rather than the message. Callers that report
+// errors from Lua code should pass such errors through UnwrapOperationError
+// first, or use AsOperationError to inspect the OperationError directly.
+type OperationError struct {
+	// Err is the underlying error. If it is or wraps a cty.PathError then
+	// the path of that error is exposed to Lua.
+	Err error
+
+	// Kind is the kind of error, as described above.
+	Kind string
+
+	// Arg is the index of the function argument that the error relates to,
+	// counting from one, or zero if the error doesn't relate to an
+	// argument.
+	Arg int
+
+	// Pos is the source position where the error was raised, in the form
+	// Lua uses to prefix error messages, or empty if it is not known.
+	Pos string
+}
+
+func (e *OperationError) Error() string {
+	if e.Pos == "" {
+		return e.Err.Error()
+	}
+	return e.Pos + " " + e.Err.Error()
+}
+
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+// AsOperationError returns the OperationError raised in Lua that caused the
+// given error, if err is a *lua.ApiError whose error object was raised by
+// an operation on a wrapped value.
+//
+// The Error method of such a *lua.ApiError does not include the message of
+// the OperationError, so callers should use this function to recover the
+// message before reporting the error.
+func AsOperationError(err error) (*OperationError, bool) {
+	var apiErr *lua.ApiError
+	if !errors.As(err, &apiErr) {
+		return nil, false
+	}
+	ud, isUD := apiErr.Object.(*lua.LUserData)
+	if !isUD {
+		return nil, false
+	}
+	opErr, isOp := ud.Value.(*OperationError)
+	return opErr, isOp
+}
+
+// UnwrapOperationError returns the OperationError raised in Lua that caused
+// the given error, if there is one, or err itself otherwise.
+//
+// It is intended for errors returned by lua.LState methods such as
+// DoString and PCall, so that the result describes an OperationError with
+// its message rather than as "userdata: 0x...":
+//
+//     if err := L.DoString(src); err != nil {
+//         return luacty.UnwrapOperationError(err)
+//     }
+func UnwrapOperationError(err error) error {
+	if opErr, isOp := AsOperationError(err); isOp {
+		return opErr
+	}
+	return err
+}
+
+// raiseError raises an OperationError of the given kind as a Lua error.
+// Like lua.LState.Error, it does not return.
+func (c *Converter) raiseError(L *lua.LState, kind string, err error) {
+	c.raiseArgError(L, kind, 0, err)
+}
+
+// raiseArgError is like raiseError but also records the index of the
+// argument that the error relates to, counting from one.
+func (c *Converter) raiseArgError(L *lua.LState, kind string, arg int, err error) {
+	ud := L.NewUserData()
+	ud.Value = &OperationError{
+		Err:  err,
+		Kind: kind,
+		Arg:  arg,
+		Pos:  L.Where(1),
+	}
+	ud.Metatable = c.errorMetatable
+	L.Error(ud, 1)
+}
+
+// raiseErrorf is a helper for raising an OperationError with a message
+// built in the same manner as fmt.Errorf.
+func (c *Converter) raiseErrorf(L *lua.LState, kind string, format string, args ...interface{}) {
+	c.raiseError(L, kind, fmt.Errorf(format, args...))
+}
+
+func (c *Converter) ctyErrorMetatable() *lua.LTable {
+	L := c.lstate
+	table := L.NewTable()
+
+	table.RawSet(lua.LString("__index"), L.NewFunction(c.ctyErrorIndex))
+	table.RawSet(lua.LString("__tostring"), L.NewFunction(c.ctyErrorToString))
+	table.RawSet(lua.LString("__newindex"), L.NewFunction(c.ctyInvalidOp("error is immutable")))
+
+	return table
+}
+
+func (c *Converter) checkOperationError(L *lua.LState, n int) *OperationError {
+	ud := L.CheckUserData(n)
+	err, isErr := ud.Value.(*OperationError)
+	if !isErr {
+		L.ArgError(n, "an error is required")
+	}
+	return err
+}
+
+func (c *Converter) ctyErrorIndex(L *lua.LState) int {
+	err := c.checkOperationError(L, 1)
+	key := L.CheckString(2)
+
+	switch key {
+	case "message":
+		L.Push(lua.LString(err.Err.Error()))
+	case "kind":
+		L.Push(lua.LString(err.Kind))
+	case "arg":
+		if err.Arg == 0 {
+			L.Push(lua.LNil)
+		} else {
+			L.Push(lua.LNumber(err.Arg))
+		}
+	case "path":
+		var pathErr cty.PathError
+		if !errors.As(err.Err, &pathErr) {
+			L.Push(lua.LNil)
+			return 1
+		}
+		L.Push(c.luaPathTable(pathErr.Path))
+	default:
+		L.Push(lua.LNil)
+	}
+	return 1
+}
+
+func (c *Converter) ctyErrorToString(L *lua.LState) int {
+	err := c.checkOperationError(L, 1)
+	L.Push(lua.LString(err.Error()))
+	return 1
+}
+
+// luaPathTable returns a Lua sequence representing the given path, using
+// the same representation accepted for the "path" field of error tables
+// raised by Lua functions called as cty functions.
+func (c *Converter) luaPathTable(path cty.Path) *lua.LTable {
+	table := c.lstate.CreateTable(len(path), 0)
+	for _, step := range path {
+		switch step := step.(type) {
+		case cty.GetAttrStep:
+			table.Append(lua.LString(step.Name))
+		case cty.IndexStep:
+			key, err := c.ToLuaValue(step.Key, nil)
+			if err != nil {
+				// Keys of unknown or marked values can't be represented,
+				// so we'll just leave a placeholder in the sequence.
+				key = lua.LFalse
+			}
+			table.Append(key)
+		}
+	}
+	return table
+}
+
+// sortErrorsByPath sorts the given errors in place by the paths of any
+// cty.PathError they wrap, and then by message. Errors without paths sort
+// as if they had an empty path.
+func sortErrorsByPath(errs []error) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		var pathI, pathJ cty.Path
+		var pathErr cty.PathError
+		if errors.As(errs[i], &pathErr) {
+			pathI = pathErr.Path
+		}
+		if errors.As(errs[j], &pathErr) {
+			pathJ = pathErr.Path
+		}
+		if cmp := comparePaths(pathI, pathJ); cmp != 0 {
+			return cmp < 0
+		}
+		return errs[i].Error() < errs[j].Error()
+	})
+}
+
+// comparePaths returns a negative number if path a sorts before path b, a
+// positive number if it sorts after, or zero if they are equal.
+//
+// Paths sort step by step, with attribute steps before index steps and
+// a path before any longer path that it is a prefix of.
+func comparePaths(a, b cty.Path) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if cmp := comparePathSteps(a[i], b[i]); cmp != 0 {
+			return cmp
+		}
+	}
+	return len(a) - len(b)
+}
+
+func comparePathSteps(a, b cty.PathStep) int {
+	switch a := a.(type) {
+	case cty.GetAttrStep:
+		b, isAttr := b.(cty.GetAttrStep)
+		if !isAttr {
+			return -1
+		}
+		return strings.Compare(a.Name, b.Name)
+	case cty.IndexStep:
+		b, isIndex := b.(cty.IndexStep)
+		if !isIndex {
+			return 1
+		}
+		return compareIndexKeys(a.Key, b.Key)
+	default:
+		return 0
+	}
+}
+
+// compareIndexKeys compares two index keys, ordering numbers numerically
+// and strings lexically. Keys of other types, or unknown keys, sort last.
+func compareIndexKeys(a, b cty.Value) int {
+	aOk := a.IsKnown() && !a.IsNull()
+	bOk := b.IsKnown() && !b.IsNull()
+	switch {
+	case !aOk || !bOk:
+		return boolCompare(aOk, bOk)
+	case a.Type() == cty.Number && b.Type() == cty.Number:
+		return a.AsBigFloat().Cmp(b.AsBigFloat())
+	case a.Type() == cty.String && b.Type() == cty.String:
+		return strings.Compare(a.AsString(), b.AsString())
+	case a.Type() == cty.Number:
+		return -1
+	case b.Type() == cty.Number:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// boolCompare orders true before false.
+func boolCompare(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return -1
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,412 @@
+package luacty
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+)
+
+func TestConverterToCtyFunctionErrors(t *testing.T) {
+	L := lua.NewState()
+	conv := NewConverter(L)
+	conv.PreloadModule("cty")
+	if err := L.DoString(`cty = require("cty")`); err != nil {
+		t.Fatalf("failed to load module: %s", err)
+	}
+
+	err := L.DoString(`
+		arg_error = function(a, b)
+			error({arg = 2, msg = "must be positive"})
+		end
+		path_error = function(a)
+			error({path = {"servers", 2, "port"}, msg = "invalid port"})
+		end
+		string_error = function()
+			error("oh no")
+		end
+		bad_arg_error = function(a)
+			error({arg = 5, msg = "no such argument"})
+		end
+		other_error = function()
+			error(true)
+		end
+		arg_path_error = function(a, b)
+			error({arg = 2, path = {"servers", 2, "port"}, msg = "invalid port"})
+		end
+	`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	call := func(name string, args ...cty.Value) error {
+		t.Helper()
+		f := conv.ToCtyFunction(L.GetGlobal(name).(*lua.LFunction))
+		_, err := f.Call(args)
+		if err == nil {
+			t.Fatalf("call to %s succeeded; want error", name)
+		}
+		return err
+	}
+
+	t.Run("argument error", func(t *testing.T) {
+		err := call("arg_error", cty.NumberIntVal(1), cty.NumberIntVal(-1))
+		var argErr function.ArgError
+		if !errors.As(err, &argErr) {
+			t.Fatalf("wrong error type %T; want function.ArgError", err)
+		}
+		if got, want := argErr.Index, 1; got != want {
+			t.Errorf("wrong argument index %d; want %d", got, want)
+		}
+		if got, want := err.Error(), "must be positive"; got != want {
+			t.Errorf("wrong error message\ngot:  %s\nwant: %s", got, want)
+		}
+	})
+	t.Run("path error", func(t *testing.T) {
+		err := call("path_error", cty.EmptyObjectVal)
+		var pathErr cty.PathError
+		if !errors.As(err, &pathErr) {
+			t.Fatalf("error does not wrap cty.PathError")
+		}
+		want := cty.GetAttrPath("servers").IndexInt(2).GetAttr("port")
+		if !pathErr.Path.Equals(want) {
+			t.Errorf("wrong path\ngot:  %#v\nwant: %#v", pathErr.Path, want)
+		}
+	})
+	t.Run("argument path error", func(t *testing.T) {
+		err := call("arg_path_error", cty.NumberIntVal(1), cty.EmptyObjectVal)
+		var argErr function.ArgError
+		if !errors.As(err, &argErr) {
+			t.Fatalf("error does not wrap function.ArgError")
+		}
+		if got, want := argErr.Index, 1; got != want {
+			t.Errorf("wrong argument index %d; want %d", got, want)
+		}
+		var pathErr cty.PathError
+		if !errors.As(err, &pathErr) {
+			t.Fatalf("error does not wrap cty.PathError")
+		}
+		want := cty.GetAttrPath("servers").IndexInt(2).GetAttr("port")
+		if !pathErr.Path.Equals(want) {
+			t.Errorf("wrong path\ngot:  %#v\nwant: %#v", pathErr.Path, want)
+		}
+		if got, want := err.Error(), "invalid port"; got != want {
+			t.Errorf("wrong error message\ngot:  %s\nwant: %s", got, want)
+		}
+	})
+	t.Run("string error", func(t *testing.T) {
+		err := call("string_error")
+		var luaErr *LuaError
+		if !errors.As(err, &luaErr) {
+			t.Fatalf("wrong error type %T; want *LuaError", err)
+		}
+		if got, want := luaErr.Message, "oh no"; got != want {
+			t.Errorf("wrong message %q; want %q", got, want)
+		}
+		if got, want := luaErr.File, "<string>"; got != want {
+			t.Errorf("wrong file %q; want %q", got, want)
+		}
+		if got, want := luaErr.Line, 9; got != want {
+			t.Errorf("wrong line %d; want %d", got, want)
+		}
+		if !strings.Contains(luaErr.Stack, "stack traceback") {
+			t.Errorf("missing stack traceback\ngot: %s", luaErr.Stack)
+		}
+		if got, want := err.Error(), "<string>:9: oh no"; got != want {
+			t.Errorf("wrong error message\ngot:  %s\nwant: %s", got, want)
+		}
+	})
+	t.Run("argument out of range", func(t *testing.T) {
+		err := call("bad_arg_error", cty.True)
+		var argErr function.ArgError
+		if errors.As(err, &argErr) {
+			t.Errorf("got function.ArgError for out-of-range argument")
+		}
+		if got, want := err.Error(), "no such argument"; got != want {
+			t.Errorf("wrong error message\ngot:  %s\nwant: %s", got, want)
+		}
+	})
+	t.Run("other error value", func(t *testing.T) {
+		err := call("other_error")
+		var luaErr *LuaError
+		if !errors.As(err, &luaErr) {
+			t.Fatalf("wrong error type %T; want *LuaError", err)
+		}
+		if got, want := luaErr.Message, "(error object is a boolean value)"; got != want {
+			t.Errorf("wrong message %q; want %q", got, want)
+		}
+	})
+}
+
+func TestOperationError(t *testing.T) {
+	tests := map[string]struct {
+		Values map[string]cty.Value
+		Assert string
+	}{
+		"arithmetic": {
+			map[string]cty.Value{
+				"n": cty.NumberIntVal(1),
+			},
+			`
+				local ok, err = pcall(function() return n + "a" end)
+				assert(not ok)
+				assert(err.kind == "conversion")
+				assert(err.message == "a number is required")
+				assert(err.arg == nil)
+				assert(tostring(err) == "<string>:2: a number is required")
+			`,
+		},
+		"concat path": {
+			map[string]cty.Value{
+				"s": cty.StringVal("a"),
+			},
+			`
+				local ok, err = pcall(function() return s .. {} end)
+				assert(not ok)
+				assert(err.kind == "conversion")
+				assert(#err.path == 0)
+			`,
+		},
+		"index": {
+			map[string]cty.Value{
+				"l": cty.ListValEmpty(cty.String),
+			},
+			`
+				local ok, err = pcall(function() return l.foo end)
+				assert(not ok)
+				assert(err.kind == "index")
+			`,
+		},
+		"index null": {
+			map[string]cty.Value{
+				"l": cty.NullVal(cty.List(cty.String)),
+				"o": cty.NullVal(cty.Object(map[string]cty.Type{
+					"a": cty.String,
+				})),
+			},
+			`
+				local ok, err = pcall(function() return l[0] end)
+				assert(not ok)
+				assert(err.kind == "index")
+				assert(err.message == "can't index a null value")
+
+				ok, err = pcall(function() return o.a end)
+				assert(not ok)
+				assert(err.kind == "index")
+				assert(err.message == "can't index a null value")
+
+				assert(l:is_null())
+			`,
+		},
+		"concat null": {
+			map[string]cty.Value{
+				"s": cty.NullVal(cty.String),
+				"t": cty.StringVal("a"),
+			},
+			`
+				local ok, err = pcall(function() return s .. "x" end)
+				assert(not ok)
+				assert(err.kind == "operation")
+				assert(err.message == "can't concatenate a null value")
+
+				ok, err = pcall(function() return "x" .. s end)
+				assert(not ok)
+				assert(err.kind == "operation")
+
+				ok, err = pcall(function() return t .. s end)
+				assert(not ok)
+				assert(err.kind == "operation")
+			`,
+		},
+		"function argument": {
+			map[string]cty.Value{},
+			`
+				local ok, err = pcall(upper, "a", "b")
+				assert(not ok)
+				assert(err.kind == "argument")
+				assert(err.arg == 2)
+
+				ok, err = pcall(upper, {})
+				assert(not ok)
+				assert(err.kind == "argument")
+				assert(err.arg == 1)
+			`,
+		},
+		"function call": {
+			map[string]cty.Value{
+				"obj": cty.ObjectVal(map[string]cty.Value{
+					"servers": cty.ListVal([]cty.Value{
+						cty.ObjectVal(map[string]cty.Value{
+							"port": cty.StringVal("http"),
+						}),
+					}),
+				}),
+			},
+			`
+				local ok, err = pcall(check, obj)
+				assert(not ok)
+				assert(err.kind == "call")
+				assert(err.arg == 1)
+				assert(err.message == "invalid port")
+				assert(err.path == nil)
+
+				ok, err = pcall(check_wrapped, obj)
+				assert(not ok)
+				assert(err.kind == "call")
+				assert(err.arg == 1)
+				assert(err.message == "checking ports: invalid port")
+
+				ok, err = pcall(check_path, obj)
+				assert(not ok)
+				assert(err.kind == "call")
+				assert(err.arg == nil)
+				assert(#err.path == 3)
+				assert(err.path[1] == "servers")
+				assert(err.path[2] == 0)
+				assert(err.path[3] == "port")
+			`,
+		},
+	}
+
+	checkPort := func(args []cty.Value) error {
+		return function.NewArgErrorf(0, "invalid port")
+	}
+	checkWrapped := func(args []cty.Value) error {
+		return fmt.Errorf("checking ports: %w", checkPort(args))
+	}
+	checkPath := func(args []cty.Value) error {
+		return cty.GetAttrPath("servers").IndexInt(0).GetAttr("port").NewErrorf("invalid port")
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L)
+			addTestFuncs(L, t)
+
+			for n, v := range test.Values {
+				L.SetGlobal(n, conv.WrapCtyValue(v))
+			}
+			L.SetGlobal("upper", conv.WrapCtyFunction(stdlib.UpperFunc))
+			for n, impl := range map[string]func([]cty.Value) error{
+				"check":         checkPort,
+				"check_wrapped": checkWrapped,
+				"check_path":    checkPath,
+			} {
+				impl := impl
+				L.SetGlobal(n, conv.WrapCtyFunction(function.New(&function.Spec{
+					Params: []function.Parameter{
+						{Name: "v", Type: cty.DynamicPseudoType},
+					},
+					Type: function.StaticReturnType(cty.Bool),
+					Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+						return cty.DynamicVal, impl(args)
+					},
+				})))
+			}
+
+			err := L.DoString(test.Assert)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestAsOperationError(t *testing.T) {
+	L := lua.NewState()
+	conv := NewConverter(L)
+	L.SetGlobal("n", conv.WrapCtyValue(cty.NumberIntVal(1)))
+	L.SetGlobal("cty_null", conv.WrapCtyValue(cty.NullVal(cty.Number)))
+	L.SetGlobal("cty_list", conv.WrapCtyValue(cty.ListValEmpty(cty.String)))
+	conv.PreloadModule("cty")
+	if err := L.DoString(`cty = require("cty")`); err != nil {
+		t.Fatalf("failed to load module: %s", err)
+	}
+
+	err := L.DoString(`return n + "a"`)
+	opErr, ok := AsOperationError(err)
+	if !ok {
+		t.Fatalf("not an operation error: %s", err)
+	}
+	if got, want := opErr.Kind, "conversion"; got != want {
+		t.Errorf("wrong kind %q; want %q", got, want)
+	}
+	var pathErr cty.PathError
+	if !errors.As(opErr, &pathErr) {
+		t.Errorf("operation error does not wrap cty.PathError")
+	}
+
+	// GopherLua can't describe the error object itself, so the message is
+	// available only from the OperationError.
+	err = L.DoString(`return cty_null + 1`)
+	if got := err.Error(); !strings.HasPrefix(got, "userdata: 0x") {
+		t.Errorf("wrong error message from DoString\ngot:  %s\nwant: userdata: 0x...", got)
+	}
+	if got, want := UnwrapOperationError(err).Error(), "<string>:1: argument must not be null"; got != want {
+		t.Errorf("wrong error message\ngot:  %s\nwant: %s", got, want)
+	}
+	if got := UnwrapOperationError(fmt.Errorf("running script: %w", err)); got.Error() != "<string>:1: argument must not be null" {
+		t.Errorf("wrapped error was not unwrapped: %s", got)
+	}
+
+	// Argument errors from methods and module functions are operation
+	// errors too
+	argTests := map[string]struct {
+		Src string
+		Arg int
+		Msg string
+	}{
+		"index key": {
+			`return cty_list:index("a")`,
+			2,
+			"<string>:1: invalid key for list of string: a number is required",
+		},
+		"convert type": {
+			`return n:convert("bogus")`,
+			2,
+			`<string>:1: invalid type expression at character 1: the keyword "bogus" is not a valid type specification`,
+		},
+		"get_attr name": {
+			`return cty.methods(n):get_attr({})`,
+			2,
+			"<string>:1: a string is required",
+		},
+		"pairs": {
+			`return cty.pairs(n)`,
+			1,
+			"<string>:1: can't iterate over number",
+		},
+		"ipairs": {
+			`return cty.ipairs(cty.map({a = 1}))`,
+			1,
+			"<string>:1: can't iterate over map of number with ipairs; use pairs instead",
+		},
+	}
+	for name, test := range argTests {
+		t.Run(name, func(t *testing.T) {
+			err := L.DoString(test.Src)
+			opErr, ok := AsOperationError(err)
+			if !ok {
+				t.Fatalf("not an operation error: %v", err)
+			}
+			if opErr.Kind != "argument" || opErr.Arg != test.Arg {
+				t.Errorf("wrong kind %q and argument %d; want \"argument\" and %d", opErr.Kind, opErr.Arg, test.Arg)
+			}
+			if got, want := opErr.Error(), test.Msg; got != want {
+				t.Errorf("wrong error message\ngot:  %s\nwant: %s", got, want)
+			}
+		})
+	}
+
+	err = L.DoString(`error("not an operation error")`)
+	if _, ok := AsOperationError(err); ok {
+		t.Errorf("string error was reported as an operation error")
+	}
+	if got := UnwrapOperationError(err); got != err {
+		t.Errorf("wrong result %#v; want the original error", got)
+	}
+}
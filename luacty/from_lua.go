@@ -1,6 +1,11 @@
 package luacty
 
 import (
+	"math"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zclconf/go-cty/cty"
 	"github.com/zclconf/go-cty/cty/convert"
@@ -10,9 +15,10 @@ import (
 // given type.
 //
 // If the given type is cty.DynamicPseudoType then this method will select
-// a cty type automatically based on the Lua value type, which is an obvious
-// mapping for most types but note that Lua tables are always converted to
-// object types unless specifically typed other wise.
+// a cty type automatically based on the Lua value type, as described for
+// ImpliedCtyType. This is an obvious mapping for most types, but note that
+// Lua tables are converted to object types or tuple types depending on their
+// keys, unless specifically typed otherwise.
 //
 // If the requested conversion is not possible -- because the given Lua value
 // is not of a suitable type for the target type -- the result is cty.DynamicVal
@@ -21,42 +27,270 @@ import (
 // Not all Lua types have corresponding cty types; those that don't will
 // produce an error regardless of the target type.
 //
+// When converting a table to an object type, attributes that are not set
+// in the table are null. If the object type has optional attributes, as
+// produced by cty.ObjectWithOptionalAttrs, then only those attributes may
+// be omitted and any others are required. ToCtyValueWithDefaults can
+// additionally substitute default values for omitted optional attributes.
+//
+// Tables with keys that the target type doesn't expect are errors, unless
+// ToCtyValueWithOptions is used to select a different policy.
+//
 // Error messages are written with a Lua developer as the audience, and so
 // will not include Go-specific implementation details. Where possible, the
 // result is a cty.PathError describing the location of the error within
 // the given data structure.
 func (c *Converter) ToCtyValue(val lua.LValue, ty cty.Type) (cty.Value, error) {
+	return c.ToCtyValueWithOptions(val, ty, nil)
+}
+
+// ToCtyOptions customizes the behavior of ToCtyValueWithOptions and
+// ToCtyValueAllErrors.
+//
+// The zero value of ToCtyOptions selects the same behavior as ToCtyValue.
+type ToCtyOptions struct {
+	// UnexpectedKeys is the policy for table keys that don't correspond to
+	// an attribute of the target object type or an element of the target
+	// list, set or tuple type.
+	UnexpectedKeys UnexpectedKeyPolicy
+
+	// KeyPrefixes selects policies for unexpected keys that are strings
+	// with particular prefixes, overriding UnexpectedKeys. If more than one
+	// prefix matches a key, the longest takes priority.
+	//
+	// Keys matching a prefix whose policy is UnexpectedKeysIgnore are also
+	// left out of automatically-chosen object types, so that tables can
+	// carry private helper fields such as "_comment" that never reach cty.
+	KeyPrefixes map[string]UnexpectedKeyPolicy
+
+	// OnUnexpectedKey is called for each unexpected key whose policy is
+	// UnexpectedKeysWarn, with an error describing the problem as would be
+	// returned for UnexpectedKeysError. It may be nil to ignore such keys.
+	OnUnexpectedKey func(err error)
+
+	// Defaults, if not nil, gives default values to substitute for optional
+	// attributes that were not set, as described for ToCtyValueWithDefaults.
+	Defaults *Defaults
+}
+
+// UnexpectedKeyPolicy is the type of the UnexpectedKeys field of
+// ToCtyOptions, selecting what happens when a table has a key that the
+// target type doesn't expect.
+type UnexpectedKeyPolicy int
+
+const (
+	// UnexpectedKeysError causes conversion to fail with an error.
+	UnexpectedKeysError UnexpectedKeyPolicy = iota
+
+	// UnexpectedKeysIgnore causes the key and its value to be ignored.
+	UnexpectedKeysIgnore
+
+	// UnexpectedKeysWarn causes the key and its value to be ignored, but
+	// reports the key to the OnUnexpectedKey function in ToCtyOptions.
+	UnexpectedKeysWarn
+)
+
+// ToCtyValueWithOptions is like ToCtyValue but accepts options to customize
+// its behavior. The options may be nil to select the default behavior.
+func (c *Converter) ToCtyValueWithOptions(val lua.LValue, ty cty.Type, opts *ToCtyOptions) (cty.Value, error) {
+	s := &toCtyState{}
+	if opts != nil {
+		s.opts = *opts
+	}
+
 	// 'path' starts off as empty but will grow for each level of recursive
 	// call we make, so by the time toCtyValue returns it is likely to have
 	// unused capacity on the end of it, depending on how deeply-recursive
 	// the given Type is.
 	path := make(cty.Path, 0)
-	return c.toCtyValue(val, ty, path)
+	ret, err := c.toCtyValue(s, val, ty, path)
+	if err != nil {
+		return ret, err
+	}
+	return s.opts.Defaults.apply(ret, path)
+}
+
+// ToCtyValueAllErrors is like ToCtyValue except that it does not stop at
+// the first error, instead continuing to convert the rest of the given
+// value so that all of the problems with it can be reported at once. This
+// includes problems found while choosing types automatically, such as when
+// the given type is cty.DynamicPseudoType.
+//
+// If conversion fails, the result is cty.DynamicVal and at least one error.
+// The errors are ordered by the paths of the values they relate to, with
+// attribute names and map keys in lexical order and list and tuple indices
+// in numeric order, so the result is the same for each call with the same
+// value.
+//
+// The given options are used as for ToCtyValueWithOptions, and may be nil
+// to select the default behavior. Default values are substituted only if
+// conversion succeeds, and so an invalid default value is reported only
+// if there are no other errors.
+func (c *Converter) ToCtyValueAllErrors(val lua.LValue, ty cty.Type, opts *ToCtyOptions) (cty.Value, []error) {
+	s := &toCtyState{allErrors: true}
+	if opts != nil {
+		s.opts = *opts
+	}
+	path := make(cty.Path, 0)
+	ret, err := c.toCtyValue(s, val, ty, path)
+	if err == nil {
+		ret, err = s.opts.Defaults.apply(ret, path)
+		if err != nil {
+			return cty.DynamicVal, []error{err}
+		}
+		return ret, nil
+	}
+	if len(s.errs) == 0 {
+		// Should not happen, because all errors are recorded as they
+		// are detected, but we'll be robust about it.
+		s.errs = append(s.errs, err)
+	}
+	sortErrorsByPath(s.errs)
+	return cty.DynamicVal, s.errs
+}
+
+// toCtyState is the state for a single call to ToCtyValue or one of its
+// variants, shared by all of the recursive calls to toCtyValue.
+type toCtyState struct {
+	opts ToCtyOptions
+
+	// allErrors is set when all errors should be collected in errs rather
+	// than just returning the first error encountered.
+	allErrors bool
+	errs      []error
+
+	// visiting tracks the tables that are currently being visited by
+	// impliedCtyType, so that it can detect reference cycles.
+	visiting map[*lua.LTable]bool
+}
+
+// unexpectedKey deals with a table key that the target type doesn't expect,
+// using the policy selected in the options. If the policy calls for an
+// error then the given error is handled as for nested.
+func (s *toCtyState) unexpectedKey(key lua.LValue, err error, first *error) {
+	switch s.keyPolicy(key) {
+	case UnexpectedKeysIgnore:
+		return
+	case UnexpectedKeysWarn:
+		if s.opts.OnUnexpectedKey != nil {
+			s.opts.OnUnexpectedKey(err)
+		}
+		return
+	default:
+		s.nested(s.record(err), first)
+	}
+}
+
+// keyPolicy returns the policy for the given key if it is unexpected.
+func (s *toCtyState) keyPolicy(key lua.LValue) UnexpectedKeyPolicy {
+	policy := s.opts.UnexpectedKeys
+	str, isStr := key.(lua.LString)
+	if !isStr {
+		return policy
+	}
+	longest := -1
+	for prefix, prefixPolicy := range s.opts.KeyPrefixes {
+		if len(prefix) > longest && strings.HasPrefix(string(str), prefix) {
+			policy = prefixPolicy
+			longest = len(prefix)
+		}
+	}
+	return policy
+}
+
+// privateKey returns true if the given key should be left out of any
+// automatically-chosen type, as described for ToCtyOptions.KeyPrefixes.
+func (s *toCtyState) privateKey(key lua.LValue) bool {
+	if _, isStr := key.(lua.LString); !isStr || len(s.opts.KeyPrefixes) == 0 {
+		return false
+	}
+	return s.keyPolicy(key) == UnexpectedKeysIgnore
+}
+
+// enter records that the given table is being visited.
+func (s *toCtyState) enter(table *lua.LTable) {
+	if s.visiting == nil {
+		s.visiting = make(map[*lua.LTable]bool)
+	}
+	s.visiting[table] = true
+}
+
+// leave records that the given table is no longer being visited.
+func (s *toCtyState) leave(table *lua.LTable) {
+	delete(s.visiting, table)
+}
+
+// record records the given error if all errors are being collected, and
+// then returns it.
+func (s *toCtyState) record(err error) error {
+	if s.allErrors {
+		s.errs = append(s.errs, err)
+	}
+	return err
+}
+
+// fail is a helper for returning a newly-detected error from a conversion
+// function, recording it if all errors are being collected.
+func (s *toCtyState) fail(err error) (cty.Value, error) {
+	return cty.DynamicVal, s.record(err)
+}
+
+// failType is like fail but for the functions that choose types.
+func (s *toCtyState) failType(err error) (cty.Type, error) {
+	return cty.DynamicPseudoType, s.record(err)
+}
+
+// nested deals with an error, which has already been recorded, from the
+// conversion of a nested value. It returns true if the caller should stop
+// and return that error immediately; otherwise the caller should continue
+// with the remaining nested values and then return the first error, which
+// nested retains in *first.
+func (s *toCtyState) nested(err error, first *error) bool {
+	if *first == nil {
+		*first = err
+	}
+	return !s.allErrors
 }
 
-func (c *Converter) toCtyValue(val lua.LValue, ty cty.Type, path cty.Path) (cty.Value, error) {
+func (c *Converter) toCtyValue(s *toCtyState, val lua.LValue, ty cty.Type, path cty.Path) (cty.Value, error) {
 	if val.Type() == lua.LTNil {
-		return cty.NullVal(ty), nil
+		// Optional attributes are a conversion-time concept only, so our
+		// result must never have a type that includes them.
+		return cty.NullVal(ty.WithoutOptionalAttributesDeep()), nil
 	}
 
 	if ty == cty.DynamicPseudoType {
 		// Choose a type automatically
 		var err error
-		ty, err = c.impliedCtyType(val, path)
+		ty, err = c.impliedCtyType(s, val, path)
 		if err != nil {
+			// impliedCtyType has already recorded its errors
 			return cty.DynamicVal, err
 		}
 	}
 
-	// If the value is a userdata produced by this package then we will
-	// unwrap it and attempt conversion using the standard cty conversion
-	// logic.
+	if ty.Equals(LuaValueType) {
+		return luaValueVal(val), nil
+	}
+
+	// If the value is a userdata produced by this package, or one that
+	// represents a registered capsule type, then we will unwrap it and
+	// attempt conversion using the standard cty conversion logic.
 	if val.Type() == lua.LTUserData {
 		ud := val.(*lua.LUserData)
-		if ctyV, isCty := ud.Value.(cty.Value); isCty {
+		ctyV, isCty := ud.Value.(cty.Value)
+		if capsuleTy, isCapsule := c.capsuleType(ud); isCapsule {
+			var err error
+			ctyV, err = capsuleVal(capsuleTy, ud, path)
+			if err != nil {
+				return s.fail(err)
+			}
+			isCty = true
+		}
+		if isCty {
 			ret, err := convert.Convert(ctyV, ty)
 			if err != nil {
-				return cty.DynamicVal, path.NewError(err)
+				return s.fail(path.NewError(err))
 			}
 			return ret, nil
 		}
@@ -71,180 +305,184 @@ func (c *Converter) toCtyValue(val lua.LValue, ty cty.Type, path cty.Path) (cty.
 	case ty == cty.Number:
 		switch val.Type() {
 		case lua.LTNumber:
-			nv := float64(val.(lua.LNumber))
-			return cty.NumberFloatVal(nv), nil
+			return c.numberVal(val.(lua.LNumber)), nil
 		default:
-			dyVal, err := c.toCtyValue(val, cty.DynamicPseudoType, path)
+			dyVal, err := c.toCtyValue(s, val, cty.DynamicPseudoType, path)
 			if err != nil {
 				return cty.DynamicVal, err
 			}
 			numV, err := convert.Convert(dyVal, cty.Number)
 			if err != nil {
-				return cty.DynamicVal, path.NewError(err)
+				return s.fail(path.NewError(err))
 			}
 			return numV, nil
 		}
 	case ty == cty.String:
 		switch val.Type() {
 		case lua.LTString:
-			nv := string(val.(lua.LString))
-			return cty.StringVal(nv), nil
+			ret, err := c.stringVal(val.(lua.LString), path)
+			if err != nil {
+				return s.fail(err)
+			}
+			return ret, nil
 		default:
 			if !lua.LVCanConvToString(val) {
-				return cty.DynamicVal, path.NewErrorf("a string is required")
+				return s.fail(path.NewErrorf("a string is required"))
 			}
 			return cty.StringVal(lua.LVAsString(val)), nil
 		}
+	case ty.Equals(BytesType):
+		str, isStr := val.(lua.LString)
+		if !isStr {
+			return s.fail(path.NewErrorf("a string is required"))
+		}
+		return BytesVal([]byte(str)), nil
 	case ty.IsObjectType():
-		return c.toCtyObject(val, ty, path)
+		return c.toCtyObject(s, val, ty, path)
 	case ty.IsTupleType():
-		return c.toCtyTuple(val, ty, path)
+		return c.toCtyTuple(s, val, ty, path)
 	case ty.IsMapType():
-		return c.toCtyMap(val, ty, path)
+		return c.toCtyMap(s, val, ty, path)
 	case ty.IsListType() || ty.IsSetType():
-		return c.toCtyListOrSet(val, ty, path)
+		return c.toCtyListOrSet(s, val, ty, path)
 	default:
-		return cty.DynamicVal, path.NewErrorf("%s values are not allowed", val.Type().String())
+		return s.fail(path.NewErrorf("%s values are not allowed", val.Type().String()))
 	}
 }
 
-func (c *Converter) toCtyObject(val lua.LValue, ty cty.Type, path cty.Path) (cty.Value, error) {
+func (c *Converter) toCtyObject(s *toCtyState, val lua.LValue, ty cty.Type, path cty.Path) (cty.Value, error) {
 	if val.Type() != lua.LTTable {
-		return cty.DynamicVal, path.NewErrorf("a table is required")
+		return s.fail(path.NewErrorf("a table is required"))
 	}
 
 	attrs := map[string]cty.Value{}
 	table := val.(*lua.LTable)
 
-	// Make sure we have capacity in our path array for our key step
-	path = append(path, cty.PathStep(nil))
+	// If the type has any optional attributes then all of the others are
+	// required. Otherwise, we treat all attributes as optional.
+	hasOptional := len(ty.OptionalAttributes()) > 0
 
+	var firstErr error
 	atys := ty.AttributeTypes()
 	for name, aty := range atys {
-		path[len(path)-1] = cty.GetAttrStep{
-			Name: name,
-		}
 		avL := table.RawGet(lua.LString(name))
-		av, err := c.toCtyValue(avL, aty, path)
+		if avL == lua.LNil && hasOptional && !ty.AttributeOptional(name) {
+			if s.nested(s.record(path.NewErrorf("attribute %q is required", name)), &firstErr) {
+				return cty.DynamicVal, firstErr
+			}
+			continue
+		}
+
+		path := append(path, cty.GetAttrStep{
+			Name: name,
+		})
+		av, err := c.toCtyValue(s, avL, aty, path)
 		if err != nil {
-			return cty.DynamicVal, err
+			if s.nested(err, &firstErr) {
+				return cty.DynamicVal, err
+			}
+			continue
 		}
 		attrs[name] = av
 	}
 
-	var err error
 	table.ForEach(func(key lua.LValue, value lua.LValue) {
-		if err != nil {
+		if firstErr != nil && !s.allErrors {
 			return
 		}
 		if key.Type() != lua.LTString {
-			err = path.NewErrorf("unexpected key %q", key.String())
+			s.unexpectedKey(key, path.NewErrorf("unexpected key %q", key.String()), &firstErr)
+			return
+		}
+		if err := c.checkAttrName(key.(lua.LString), path); err != nil {
+			s.nested(s.record(err), &firstErr)
 			return
 		}
 		if _, expected := atys[string(key.(lua.LString))]; !expected {
-			err = path.NewErrorf("unexpected key %q", key.String())
+			s.unexpectedKey(key, path.NewErrorf("unexpected key %q", key.String()), &firstErr)
 			return
 		}
 	})
-	if err != nil {
-		return cty.DynamicVal, err
+	if firstErr != nil {
+		return cty.DynamicVal, firstErr
 	}
 
 	return cty.ObjectVal(attrs), nil
 }
 
-func (c *Converter) toCtyTuple(val lua.LValue, ty cty.Type, path cty.Path) (cty.Value, error) {
+func (c *Converter) toCtyTuple(s *toCtyState, val lua.LValue, ty cty.Type, path cty.Path) (cty.Value, error) {
 	if val.Type() != lua.LTTable {
-		return cty.DynamicVal, path.NewErrorf("a table is required")
+		return s.fail(path.NewErrorf("a table is required"))
 	}
 
 	etys := ty.TupleElementTypes()
 	elems := make([]cty.Value, len(etys))
 	table := val.(*lua.LTable)
 
-	// Make sure we have capacity in our path array for our index step
-	path = append(path, cty.PathStep(nil))
-
+	var firstErr error
 	for i, ety := range etys {
-		path[len(path)-1] = cty.IndexStep{
+		path := append(path, cty.IndexStep{
 			Key: cty.NumberIntVal(int64(i)),
-		}
+		})
 		evL := table.RawGet(lua.LNumber(float64(i + 1))) // lua tables are 1-indexed
-		ev, err := c.toCtyValue(evL, ety, path)
+		ev, err := c.toCtyValue(s, evL, ety, path)
 		if err != nil {
-			return cty.DynamicVal, err
+			if s.nested(err, &firstErr) {
+				return cty.DynamicVal, err
+			}
+			continue
 		}
 		elems[i] = ev
 	}
 
-	var err error
-	table.ForEach(func(key lua.LValue, value lua.LValue) {
-		if err != nil {
-			return
-		}
-		if key.Type() != lua.LTNumber {
-			err = path.NewErrorf("unexpected key %q", key.String())
-			return
-		}
-		i := float64(key.(lua.LNumber))
-		if i != float64(int(i)) {
-			err = path.NewErrorf("unexpected key %q", key.String())
-			return
-		}
-		if int(i) < 1 || int(i) > len(etys) {
-			err = path.NewErrorf("index out of range %d", int(i))
-			return
-		}
-	})
-	if err != nil {
-		return cty.DynamicVal, err
+	c.checkSequenceKeys(s, table, len(etys), path, &firstErr)
+	if firstErr != nil {
+		return cty.DynamicVal, firstErr
 	}
 
 	return cty.TupleVal(elems), nil
 }
 
-func (c *Converter) toCtyMap(val lua.LValue, ty cty.Type, path cty.Path) (cty.Value, error) {
+func (c *Converter) toCtyMap(s *toCtyState, val lua.LValue, ty cty.Type, path cty.Path) (cty.Value, error) {
 	if val.Type() != lua.LTTable {
-		return cty.DynamicVal, path.NewErrorf("a table is required")
+		return s.fail(path.NewErrorf("a table is required"))
 	}
 
 	ety := ty.ElementType()
 	elems := make(map[string]cty.Value)
 	table := val.(*lua.LTable)
 
-	// Make sure we have capacity in our path array for our index step
-	path = append(path, cty.PathStep(nil))
-	path = path[:len(path)-1]
-
-	var err error
+	var firstErr error
 	table.ForEach(func(key lua.LValue, value lua.LValue) {
-		if err != nil {
+		if firstErr != nil && !s.allErrors {
 			return
 		}
-		keyV, keyErr := c.toCtyValue(key, cty.String, path)
+		// Key errors are reported with our own message, so we don't want
+		// the nested conversion to record its own error.
+		keyV, keyErr := c.toCtyValue(&toCtyState{}, key, cty.String, path)
 		if keyErr != nil {
-			err = path.NewErrorf("invalid key %s: %s", key.String(), keyErr)
+			s.nested(s.record(path.NewErrorf("invalid key %s: %s", key.String(), keyErr)), &firstErr)
 			return
 		}
-		path = append(path, cty.IndexStep{
+		path := append(path, cty.IndexStep{
 			Key: keyV,
 		})
 
-		valueV, valueErr := c.toCtyValue(value, ety, path)
+		valueV, valueErr := c.toCtyValue(s, value, ety, path)
 		if valueErr != nil {
-			err = path.NewError(valueErr)
+			s.nested(valueErr, &firstErr)
 			return
 		}
 
 		elems[keyV.AsString()] = valueV
 	})
-	if err != nil {
-		return cty.DynamicVal, err
+	if firstErr != nil {
+		return cty.DynamicVal, firstErr
 	}
 
 	// If our element type is DynamicPseudoType then the caller wants us to
 	// choose a single element type to unify all of the values.
-	if ety == cty.DynamicPseudoType {
+	if ety == cty.DynamicPseudoType && len(elems) > 0 {
 		names := make([]string, len(elems))
 		etys := make([]cty.Type, len(elems))
 		i := 0
@@ -255,7 +493,7 @@ func (c *Converter) toCtyMap(val lua.LValue, ty cty.Type, path cty.Path) (cty.Va
 		}
 		uTy, convs := convert.Unify(etys)
 		if uTy == cty.NilType {
-			return cty.DynamicVal, path.NewErrorf("all values must be of the same type")
+			return s.fail(path.NewErrorf("all values must be of the same type"))
 		}
 		ety = uTy
 		for i, conv := range convs {
@@ -267,23 +505,29 @@ func (c *Converter) toCtyMap(val lua.LValue, ty cty.Type, path cty.Path) (cty.Va
 				Key: cty.StringVal(names[i]),
 			})
 
+			var err error
 			elems[names[i]], err = conv(elems[names[i]])
 			if err != nil {
-				return cty.DynamicVal, path.NewError(err)
+				if s.nested(s.record(path.NewError(err)), &firstErr) {
+					return cty.DynamicVal, firstErr
+				}
 			}
 		}
+		if firstErr != nil {
+			return cty.DynamicVal, firstErr
+		}
 	}
 
 	if len(elems) == 0 {
-		return cty.MapValEmpty(ety), nil
+		return cty.MapValEmpty(ety.WithoutOptionalAttributesDeep()), nil
 	}
 
 	return cty.MapVal(elems), nil
 }
 
-func (c *Converter) toCtyListOrSet(val lua.LValue, ty cty.Type, path cty.Path) (cty.Value, error) {
+func (c *Converter) toCtyListOrSet(s *toCtyState, val lua.LValue, ty cty.Type, path cty.Path) (cty.Value, error) {
 	if val.Type() != lua.LTTable {
-		return cty.DynamicVal, path.NewErrorf("a table is required")
+		return s.fail(path.NewErrorf("a table is required"))
 	}
 
 	table := val.(*lua.LTable)
@@ -292,53 +536,39 @@ func (c *Converter) toCtyListOrSet(val lua.LValue, ty cty.Type, path cty.Path) (
 	ety := ty.ElementType()
 	elems := make([]cty.Value, l)
 
+	var firstErr error
 	for i := 0; i < l; i++ {
 		path := append(path, cty.IndexStep{
 			Key: cty.NumberIntVal(int64(i)),
 		})
 
 		value := table.RawGetInt(i + 1)
-		valueV, valueErr := c.toCtyValue(value, ety, path)
+		valueV, valueErr := c.toCtyValue(s, value, ety, path)
 		if valueErr != nil {
-			return cty.DynamicVal, path.NewError(valueErr)
+			if s.nested(valueErr, &firstErr) {
+				return cty.DynamicVal, valueErr
+			}
+			continue
 		}
 
 		elems[i] = valueV
 	}
 
-	var err error
-	table.ForEach(func(key lua.LValue, value lua.LValue) {
-		if err != nil {
-			return
-		}
-		if key.Type() != lua.LTNumber {
-			err = path.NewErrorf("unexpected key %q", key.String())
-			return
-		}
-		i := float64(key.(lua.LNumber))
-		if i != float64(int(i)) {
-			err = path.NewErrorf("unexpected key %q", key.String())
-			return
-		}
-		if int(i) < 1 || int(i) > l {
-			err = path.NewErrorf("index out of range %d", int(i))
-			return
-		}
-	})
-	if err != nil {
-		return cty.DynamicVal, err
+	c.checkSequenceKeys(s, table, l, path, &firstErr)
+	if firstErr != nil {
+		return cty.DynamicVal, firstErr
 	}
 
 	// If our element type is DynamicPseudoType then the caller wants us to
 	// choose a single element type to unify all of the values.
-	if ety == cty.DynamicPseudoType {
+	if ety == cty.DynamicPseudoType && len(elems) > 0 {
 		etys := make([]cty.Type, len(elems))
 		for i, v := range elems {
 			etys[i] = v.Type()
 		}
 		uTy, convs := convert.Unify(etys)
 		if uTy == cty.NilType {
-			return cty.DynamicVal, path.NewErrorf("all values must be of the same type")
+			return s.fail(path.NewErrorf("all values must be of the same type"))
 		}
 		for i, conv := range convs {
 			if conv == nil {
@@ -349,18 +579,24 @@ func (c *Converter) toCtyListOrSet(val lua.LValue, ty cty.Type, path cty.Path) (
 				Key: cty.NumberIntVal(int64(i)),
 			})
 
+			var err error
 			elems[i], err = conv(elems[i])
 			if err != nil {
-				return cty.DynamicVal, path.NewError(err)
+				if s.nested(s.record(path.NewError(err)), &firstErr) {
+					return cty.DynamicVal, firstErr
+				}
 			}
 		}
+		if firstErr != nil {
+			return cty.DynamicVal, firstErr
+		}
 	}
 
 	if len(elems) == 0 {
 		if ty.IsSetType() {
-			return cty.SetValEmpty(ety), nil
+			return cty.SetValEmpty(ety.WithoutOptionalAttributesDeep()), nil
 		} else {
-			return cty.ListValEmpty(ety), nil
+			return cty.ListValEmpty(ety.WithoutOptionalAttributesDeep()), nil
 		}
 	}
 
@@ -371,19 +607,56 @@ func (c *Converter) toCtyListOrSet(val lua.LValue, ty cty.Type, path cty.Path) (
 	}
 }
 
+// checkSequenceKeys checks that the given table, which is being converted
+// to a sequence type of length l, has no keys other than the integers 1
+// through l, using s.unexpectedKey to deal with any that it does have.
+func (c *Converter) checkSequenceKeys(s *toCtyState, table *lua.LTable, l int, path cty.Path, firstErr *error) {
+	table.ForEach(func(key lua.LValue, value lua.LValue) {
+		if *firstErr != nil && !s.allErrors {
+			return
+		}
+		if key.Type() != lua.LTNumber {
+			s.unexpectedKey(key, path.NewErrorf("unexpected key %q", key.String()), firstErr)
+			return
+		}
+		i := float64(key.(lua.LNumber))
+		if i != float64(int(i)) {
+			s.unexpectedKey(key, path.NewErrorf("unexpected key %q", key.String()), firstErr)
+			return
+		}
+		if int(i) < 1 || int(i) > l {
+			s.unexpectedKey(key, path.NewErrorf("index out of range %d", int(i)), firstErr)
+			return
+		}
+	})
+}
+
 // ImpliedCtyType attempts to produce a cty Type that is suitable to recieve
 // the given Lua value, or returns an error if no mapping is possible.
 //
+// A Lua table with only string keys implies an object type. A table that is
+// a sequence, whose keys are the integers from 1 to n, implies a tuple type,
+// or a list type if the converter was created with WithSequencesAsLists and
+// the element types can be unified. Because an empty table could be either,
+// it implies the type selected with WithEmptyTableType, which defaults to
+// cty.EmptyObject, unless the table was tagged by the "array" or "dict"
+// functions in the Lua module. Tables with any other combination of keys
+// are errors.
+//
+// Tables that refer to themselves, directly or indirectly, have no
+// corresponding cty type and so are also errors, as are tables nested more
+// deeply than the limit set by WithMaxDepth.
+//
 // Error messages are written with a Lua developer as the audience, and so
 // will not include Go-specific implementation details. Where possible, the
 // result is a cty.PathError describing the location of the error within
 // the given data structure.
 func (c *Converter) ImpliedCtyType(val lua.LValue) (cty.Type, error) {
 	path := make(cty.Path, 0)
-	return c.impliedCtyType(val, path)
+	return c.impliedCtyType(&toCtyState{}, val, path)
 }
 
-func (c *Converter) impliedCtyType(val lua.LValue, path cty.Path) (cty.Type, error) {
+func (c *Converter) impliedCtyType(s *toCtyState, val lua.LValue, path cty.Path) (cty.Type, error) {
 	switch val.Type() {
 
 	case lua.LTNil:
@@ -396,55 +669,228 @@ func (c *Converter) impliedCtyType(val lua.LValue, path cty.Path) (cty.Type, err
 		return cty.Number, nil
 
 	case lua.LTString:
-		return cty.String, nil
+		return c.impliedStringType(val.(lua.LString)), nil
 
 	case lua.LTUserData:
 		ud := val.(*lua.LUserData)
 		if ctyV, isCty := ud.Value.(cty.Value); isCty {
 			return ctyV.Type(), nil
 		}
+		if ty, isCapsule := c.capsuleType(ud); isCapsule {
+			return ty, nil
+		}
 
-		// Other userdata types (presumably created by other packages) are not allowed
-		return cty.DynamicPseudoType, path.NewErrorf("userdata values are not allowed")
+		// Other userdata types (presumably created by other packages) are not
+		// allowed, unless they can be carried as opaque Lua values.
+		if c.luaValues {
+			return LuaValueType, nil
+		}
+		return s.failType(path.NewErrorf("userdata values are not allowed"))
 
 	case lua.LTTable:
 		table := val.(*lua.LTable)
-		var err error
+		if s.visiting[table] {
+			return s.failType(path.NewErrorf("table contains a reference cycle"))
+		}
+		if c.maxDepth > 0 && len(path) >= c.maxDepth {
+			return s.failType(path.NewErrorf("tables are nested too deeply; the maximum nesting depth is %d", c.maxDepth))
+		}
+		s.enter(table)
+		defer s.leave(table)
+		return c.impliedTableType(s, table, path)
 
-		// Make sure we have capacity in our path array for our key step
-		path = append(path, cty.PathStep(nil))
-		path = path[:len(path)-1]
+	default:
+		if c.luaValues {
+			return LuaValueType, nil
+		}
+		return s.failType(path.NewErrorf("%s values are not allowed", val.Type().String()))
 
-		atys := make(map[string]cty.Type)
+	}
+}
 
-		table.ForEach(func(key lua.LValue, val lua.LValue) {
-			if err != nil {
-				return
-			}
-			keyCty, keyErr := c.ToCtyValue(key, cty.String)
-			if keyErr != nil {
-				err = path.NewErrorf("all table keys must be strings")
-				return
-			}
-			attrName := keyCty.AsString()
-			keyPath := append(path, cty.GetAttrStep{
-				Name: attrName,
-			})
-			aty, valErr := c.impliedCtyType(val, keyPath)
-			if valErr != nil {
-				err = valErr
-				return
+// impliedTableType is the part of impliedCtyType that deals with tables.
+//
+// A table whose keys are all strings implies an object type, while a table
+// whose keys are exactly the integers 1 through n is a sequence and implies
+// a tuple type, or a list type if the converter was created with
+// WithSequencesAsLists. An empty table implies the type selected with
+// WithEmptyTableType, which is cty.EmptyObject by default. Any other table
+// is ambiguous, and so is an error.
+//
+// Tables tagged using the "array" and "dict" functions in the Lua module
+// are always interpreted as sequences and as objects respectively.
+func (c *Converter) impliedTableType(s *toCtyState, table *lua.LTable, path cty.Path) (cty.Type, error) {
+	isArray := table.Metatable == c.arrayMetatable
+	isDict := table.Metatable == c.dictMetatable
+
+	strKeys, numKeys, seqKeys := 0, 0, 0
+	otherKeys := false
+	table.ForEach(func(key lua.LValue, val lua.LValue) {
+		if s.privateKey(key) {
+			return
+		}
+		switch key := key.(type) {
+		case lua.LString:
+			strKeys++
+		case lua.LNumber:
+			numKeys++
+			// Because keys are unique, the keys are exactly 1 through n if
+			// they are all integers in that range.
+			if i := int(key); float64(i) == float64(key) && i >= 1 && i <= table.Len() {
+				seqKeys++
 			}
-			atys[attrName] = aty
+		default:
+			otherKeys = true
+		}
+	})
+
+	switch {
+	case otherKeys:
+		return s.failType(path.NewErrorf("all table keys must be strings or sequence indices"))
+	case numKeys > 0 && isDict:
+		return s.failType(path.NewErrorf("a table tagged as a dict must have only string keys"))
+	case numKeys == 0 && isDict:
+		return c.impliedObjectType(s, table, path)
+	case strKeys == 0 && numKeys == 0 && isArray:
+		if c.sequencesAsLists {
+			return cty.List(cty.DynamicPseudoType), nil
+		}
+		return cty.EmptyTuple, nil
+	case strKeys == 0 && numKeys == 0:
+		return c.emptyTableType, nil
+	case strKeys > 0 && isArray:
+		return s.failType(path.NewErrorf("a table tagged as an array must not have string keys"))
+	case numKeys == 0:
+		return c.impliedObjectType(s, table, path)
+	case strKeys > 0:
+		return s.failType(path.NewErrorf("table has both string keys and sequence elements; use only one or the other"))
+	case seqKeys != numKeys || seqKeys != table.Len():
+		return s.failType(path.NewErrorf("table has numeric keys that are not a sequence; keys must be the integers from 1 to the length of the table"))
+	}
+
+	ty, err := c.impliedTupleType(s, table, path)
+	if err != nil || !c.sequencesAsLists {
+		return ty, err
+	}
+
+	// If the element types can't be unified then we'll just stick with the
+	// tuple type, since it's still a reasonable interpretation.
+	if uTy, _ := convert.Unify(ty.TupleElementTypes()); uTy != cty.NilType {
+		return cty.List(uTy), nil
+	}
+	return ty, nil
+}
+
+// impliedObjectType is the part of impliedTableType that deals with tables
+// whose keys are strings, treating all of the table keys as object attribute
+// names.
+func (c *Converter) impliedObjectType(s *toCtyState, table *lua.LTable, path cty.Path) (cty.Type, error) {
+	var firstErr error
+
+	// Make sure we have capacity in our path array for our key step
+	path = append(path, cty.PathStep(nil))
+	path = path[:len(path)-1]
+
+	atys := make(map[string]cty.Type)
+
+	table.ForEach(func(key lua.LValue, val lua.LValue) {
+		if (firstErr != nil && !s.allErrors) || s.privateKey(key) {
+			return
+		}
+		attrName, err := c.attrName(key, path)
+		if err != nil {
+			s.nested(s.record(err), &firstErr)
+			return
+		}
+		keyPath := append(path, cty.GetAttrStep{
+			Name: attrName,
 		})
+		aty, err := c.impliedCtyType(s, val, keyPath)
 		if err != nil {
-			return cty.DynamicPseudoType, err
+			s.nested(err, &firstErr)
+			return
 		}
+		atys[attrName] = aty
+	})
+	if firstErr != nil {
+		return cty.DynamicPseudoType, firstErr
+	}
 
-		return cty.Object(atys), nil
+	return cty.Object(atys), nil
+}
 
-	default:
-		return cty.DynamicPseudoType, path.NewErrorf("%s values are not allowed", val.Type().String())
+// attrName returns the attribute name that the given table key represents
+// in an object type, or an error if it cannot be an attribute name.
+func (c *Converter) attrName(key lua.LValue, path cty.Path) (string, error) {
+	if str, isStr := key.(lua.LString); isStr {
+		// Attribute names are always the exact bytes of the key, because
+		// toCtyObject must be able to find the key again using the name.
+		if err := c.checkAttrName(str, path); err != nil {
+			return "", err
+		}
+		return string(str), nil
+	}
+	keyCty, err := c.ToCtyValue(key, cty.String)
+	if err != nil {
+		return "", path.NewErrorf("all table keys must be strings")
+	}
+	return keyCty.AsString(), nil
+}
+
+// checkAttrName returns an error if the given table key cannot be used as
+// an attribute name under the converter's string policy.
+//
+// Rewriting an attribute name as the string policy would rewrite a string
+// value would produce an attribute that doesn't correspond to any key of
+// the table, and so names that are not valid UTF-8 are rejected under all
+// policies other than StringsUnchecked.
+func (c *Converter) checkAttrName(key lua.LString, path cty.Path) error {
+	if c.stringPolicy != StringsUnchecked && !utf8.ValidString(string(key)) {
+		return path.NewErrorf("attribute name %q is not valid UTF-8", string(key))
+	}
+	return nil
+}
+
+// impliedTupleType is like impliedObjectType except that it treats the given
+// table as a sequence, producing a tuple type whose element types are implied
+// from the values at indices 1 through n.
+//
+// Any keys that are not part of the sequence are ignored here, but will
+// be rejected by a subsequent conversion to the returned type.
+func (c *Converter) impliedTupleType(s *toCtyState, table *lua.LTable, path cty.Path) (cty.Type, error) {
+	var firstErr error
+	l := table.Len()
+	etys := make([]cty.Type, l)
+	for i := range etys {
+		path := append(path, cty.IndexStep{
+			Key: cty.NumberIntVal(int64(i)),
+		})
+		ety, err := c.impliedCtyType(s, table.RawGetInt(i+1), path)
+		if err != nil {
+			if s.nested(err, &firstErr) {
+				return cty.DynamicPseudoType, err
+			}
+			continue
+		}
+		etys[i] = ety
+	}
+	if firstErr != nil {
+		return cty.DynamicPseudoType, firstErr
+	}
+	return cty.Tuple(etys), nil
+}
 
+// exactNumberPrecision is the precision used for numbers converted from Lua
+// when exact numbers are enabled, which matches the precision that cty uses
+// when parsing numbers from strings.
+const exactNumberPrecision = 512
+
+// numberVal converts the given Lua number to a cty number, preserving
+// integers exactly if the converter was created with WithExactNumbers.
+func (c *Converter) numberVal(n lua.LNumber) cty.Value {
+	f := float64(n)
+	if c.exactNumbers && !math.IsInf(f, 0) && f == math.Trunc(f) {
+		return cty.NumberVal(new(big.Float).SetPrec(exactNumberPrecision).SetFloat64(f))
 	}
+	return cty.NumberFloatVal(f)
 }
@@ -1,6 +1,7 @@
 package luacty
 
 import (
+	"errors"
 	"testing"
 
 	lua "github.com/yuin/gopher-lua"
@@ -318,6 +319,41 @@ func TestConverterToCtyValue(t *testing.T) {
 			cty.DynamicVal,
 			true, // all values must be of the same type
 		},
+		"table to object with optional attributes": {
+			func(L *lua.LState) lua.LValue {
+				table := L.NewTable()
+				table.RawSet(lua.LString("name"), lua.LString("hello"))
+				return table
+			},
+			cty.ObjectWithOptionalAttrs(map[string]cty.Type{
+				"name": cty.String,
+				"port": cty.Number,
+				"tags": cty.List(cty.ObjectWithOptionalAttrs(map[string]cty.Type{
+					"key": cty.String,
+				}, []string{"key"})),
+			}, []string{"port", "tags"}),
+			cty.ObjectVal(map[string]cty.Value{
+				"name": cty.StringVal("hello"),
+				"port": cty.NullVal(cty.Number),
+				"tags": cty.NullVal(cty.List(cty.Object(map[string]cty.Type{
+					"key": cty.String,
+				}))),
+			}),
+			false,
+		},
+		"table to object with optional attributes (missing required)": {
+			func(L *lua.LState) lua.LValue {
+				table := L.NewTable()
+				table.RawSet(lua.LString("port"), lua.LNumber(80))
+				return table
+			},
+			cty.ObjectWithOptionalAttrs(map[string]cty.Type{
+				"name": cty.String,
+				"port": cty.Number,
+			}, []string{"port"}),
+			cty.DynamicVal,
+			true, // attribute "name" is required
+		},
 		"table to dynamic": {
 			func(L *lua.LState) lua.LValue {
 				table := L.NewTable()
@@ -330,6 +366,84 @@ func TestConverterToCtyValue(t *testing.T) {
 			}),
 			false,
 		},
+		"table to dynamic (empty)": {
+			func(L *lua.LState) lua.LValue {
+				return L.NewTable()
+			},
+			cty.DynamicPseudoType,
+			cty.EmptyObjectVal,
+			false,
+		},
+		"table to dynamic (sequence)": {
+			func(L *lua.LState) lua.LValue {
+				table := L.NewTable()
+				table.Append(lua.LNumber(1))
+				table.Append(lua.LString("two"))
+				return table
+			},
+			cty.DynamicPseudoType,
+			cty.TupleVal([]cty.Value{
+				cty.NumberIntVal(1),
+				cty.StringVal("two"),
+			}),
+			false,
+		},
+		"table to dynamic (nested sequence)": {
+			func(L *lua.LState) lua.LValue {
+				inner := L.NewTable()
+				inner.Append(lua.LNumber(1))
+				table := L.NewTable()
+				table.RawSet(lua.LString("nums"), inner)
+				return table
+			},
+			cty.DynamicPseudoType,
+			cty.ObjectVal(map[string]cty.Value{
+				"nums": cty.TupleVal([]cty.Value{cty.NumberIntVal(1)}),
+			}),
+			false,
+		},
+		"table to dynamic (mixed keys)": {
+			func(L *lua.LState) lua.LValue {
+				table := L.NewTable()
+				table.Append(lua.LNumber(1))
+				table.RawSet(lua.LString("name"), lua.LString("hello"))
+				return table
+			},
+			cty.DynamicPseudoType,
+			cty.DynamicVal,
+			true, // table has both string keys and sequence elements
+		},
+		"table to dynamic (sparse sequence)": {
+			func(L *lua.LState) lua.LValue {
+				table := L.NewTable()
+				table.RawSetInt(1, lua.LNumber(1))
+				table.RawSetInt(3, lua.LNumber(3))
+				return table
+			},
+			cty.DynamicPseudoType,
+			cty.DynamicVal,
+			true, // table has numeric keys that are not a sequence
+		},
+		"table to dynamic (fractional key)": {
+			func(L *lua.LState) lua.LValue {
+				table := L.NewTable()
+				table.RawSet(lua.LNumber(1.5), lua.LNumber(1))
+				return table
+			},
+			cty.DynamicPseudoType,
+			cty.DynamicVal,
+			true, // table has numeric keys that are not a sequence
+		},
+		"table to dynamic (bool key)": {
+			func(L *lua.LState) lua.LValue {
+				table := L.NewTable()
+				table.RawSet(lua.LTrue, lua.LNumber(1))
+				return table
+			},
+			cty.DynamicPseudoType,
+			cty.DynamicVal,
+			true, // all table keys must be strings or sequence indices
+		},
 		"table to bool": {
 			func(L *lua.LState) lua.LValue {
 				return L.NewTable()
@@ -397,3 +511,546 @@ func TestConverterToCtyValue(t *testing.T) {
 		})
 	}
 }
+
+func TestConverterToCtyValueAllErrors(t *testing.T) {
+	L := lua.NewState()
+	conv := NewConverter(L)
+
+	err := L.DoString(`
+		config = {
+			name = {},
+			servers = {
+				{ port = "http" },
+				{ port = 8080 },
+				{ port = "https", extra = true },
+			},
+			tags = { a = "x", b = {} },
+			unexpected = true,
+		}
+	`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ty := cty.Object(map[string]cty.Type{
+		"name": cty.String,
+		"servers": cty.List(cty.Object(map[string]cty.Type{
+			"port": cty.Number,
+		})),
+		"tags": cty.Map(cty.String),
+	})
+
+	got, errs := conv.ToCtyValueAllErrors(L.GetGlobal("config"), ty, nil)
+	if got != cty.DynamicVal {
+		t.Errorf("wrong result %#v; want cty.DynamicVal", got)
+	}
+
+	type pathMsg struct {
+		Path cty.Path
+		Msg  string
+	}
+	want := []pathMsg{
+		{nil, `unexpected key "unexpected"`},
+		{cty.GetAttrPath("name"), "a string is required"},
+		{cty.GetAttrPath("servers").IndexInt(0).GetAttr("port"), "a number is required"},
+		{cty.GetAttrPath("servers").IndexInt(2), `unexpected key "extra"`},
+		{cty.GetAttrPath("servers").IndexInt(2).GetAttr("port"), "a number is required"},
+		{cty.GetAttrPath("tags").IndexString("b"), "a string is required"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("wrong number of errors %d; want %d\n%v", len(errs), len(want), errs)
+	}
+	for i, err := range errs {
+		var pathErr cty.PathError
+		if !errors.As(err, &pathErr) {
+			t.Errorf("error %d is not a cty.PathError: %s", i, err)
+			continue
+		}
+		if !pathErr.Path.Equals(want[i].Path) || err.Error() != want[i].Msg {
+			t.Errorf("wrong error %d\ngot:  %#v %s\nwant: %#v %s", i, pathErr.Path, err, want[i].Path, want[i].Msg)
+		}
+	}
+
+	// The ordinary ToCtyValue still stops at the first error
+	_, err = conv.ToCtyValue(L.GetGlobal("config"), ty)
+	if err == nil {
+		t.Errorf("conversion succeeded; want error")
+	}
+
+	// With lenient options, the unexpected keys are no longer errors
+	var warns []string
+	_, errs = conv.ToCtyValueAllErrors(L.GetGlobal("config"), ty, &ToCtyOptions{
+		UnexpectedKeys: UnexpectedKeysWarn,
+		OnUnexpectedKey: func(err error) {
+			warns = append(warns, err.Error())
+		},
+	})
+	if len(errs) != 4 {
+		t.Errorf("wrong number of errors %d with lenient options; want 4\n%v", len(errs), errs)
+	}
+	if len(warns) != 2 {
+		t.Errorf("wrong number of warnings %d; want 2\n%v", len(warns), warns)
+	}
+
+	got, errs = conv.ToCtyValueAllErrors(lua.LString("hello"), cty.String, nil)
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if !got.RawEquals(cty.StringVal("hello")) {
+		t.Errorf("wrong result %#v", got)
+	}
+}
+
+func TestConverterToCtyValueAllErrorsImpliedType(t *testing.T) {
+	L := lua.NewState()
+	conv := NewConverter(L)
+
+	err := L.DoString(`
+		config = {
+			a = {1, x = 2},
+			b = function() end,
+			c = {1, "x"},
+			d = {{}, {f = coroutine.create(function() end)}, 3, {1.5, [5] = 1}},
+		}
+	`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got, errs := conv.ToCtyValueAllErrors(L.GetGlobal("config"), cty.DynamicPseudoType, nil)
+	if got != cty.DynamicVal {
+		t.Errorf("wrong result %#v; want cty.DynamicVal", got)
+	}
+
+	type pathMsg struct {
+		Path cty.Path
+		Msg  string
+	}
+	want := []pathMsg{
+		{cty.GetAttrPath("a"), "table has both string keys and sequence elements; use only one or the other"},
+		{cty.GetAttrPath("b"), "function values are not allowed"},
+		{cty.GetAttrPath("d").IndexInt(1).GetAttr("f"), "thread values are not allowed"},
+		{cty.GetAttrPath("d").IndexInt(3), "table has numeric keys that are not a sequence; keys must be the integers from 1 to the length of the table"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("wrong number of errors %d; want %d\n%v", len(errs), len(want), errs)
+	}
+	for i, err := range errs {
+		var pathErr cty.PathError
+		if !errors.As(err, &pathErr) {
+			t.Errorf("error %d is not a cty.PathError: %s", i, err)
+			continue
+		}
+		if !pathErr.Path.Equals(want[i].Path) || err.Error() != want[i].Msg {
+			t.Errorf("wrong error %d\ngot:  %#v %s\nwant: %#v %s", i, pathErr.Path, err, want[i].Path, want[i].Msg)
+		}
+	}
+}
+
+func TestConverterImpliedCtyTypeOptions(t *testing.T) {
+	tests := map[string]struct {
+		Opts []ConverterOption
+		Src  string
+		Want cty.Type
+	}{
+		"sequence as tuple": {
+			nil,
+			`{1, "two"}`,
+			cty.Tuple([]cty.Type{cty.Number, cty.String}),
+		},
+		"sequence as list": {
+			[]ConverterOption{WithSequencesAsLists()},
+			`{1, "two"}`,
+			cty.List(cty.String),
+		},
+		"sequence as list (can't unify)": {
+			[]ConverterOption{WithSequencesAsLists()},
+			`{1, {}}`,
+			cty.Tuple([]cty.Type{cty.Number, cty.EmptyObject}),
+		},
+		"empty table default": {
+			nil,
+			`{}`,
+			cty.EmptyObject,
+		},
+		"empty table as tuple": {
+			[]ConverterOption{WithEmptyTableType(cty.EmptyTuple)},
+			`{}`,
+			cty.EmptyTuple,
+		},
+		"empty table as list": {
+			[]ConverterOption{WithEmptyTableType(cty.List(cty.DynamicPseudoType))},
+			`{names = {}}`,
+			cty.Object(map[string]cty.Type{
+				"names": cty.List(cty.DynamicPseudoType),
+			}),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L, test.Opts...)
+			if err := L.DoString("v = " + test.Src); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			got, err := conv.ImpliedCtyType(L.GetGlobal("v"))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !got.Equals(test.Want) {
+				t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, test.Want)
+			}
+
+			// The implied type must also be accepted for conversion
+			if _, err := conv.ToCtyValue(L.GetGlobal("v"), cty.DynamicPseudoType); err != nil {
+				t.Errorf("unexpected conversion error: %s", err)
+			}
+		})
+	}
+}
+
+func TestConverterToCtyValueCycles(t *testing.T) {
+	tests := map[string]struct {
+		Opts     []ConverterOption
+		Src      string
+		WantPath cty.Path
+		WantErr  string
+	}{
+		"self reference": {
+			nil,
+			`v = {}; v.self = v`,
+			cty.GetAttrPath("self"),
+			"table contains a reference cycle",
+		},
+		"indirect reference": {
+			nil,
+			`v = {a = {{}}}; v.a[1].up = v`,
+			cty.GetAttrPath("a").IndexInt(0).GetAttr("up"),
+			"table contains a reference cycle",
+		},
+		"shared reference": {
+			nil,
+			`local s = {n = 1}; v = {a = s, b = {s, s}}`,
+			nil,
+			"",
+		},
+		"too deep": {
+			[]ConverterOption{WithMaxDepth(3)},
+			`v = {a = {b = {c = {}}}}`,
+			cty.GetAttrPath("a").GetAttr("b").GetAttr("c"),
+			"tables are nested too deeply; the maximum nesting depth is 3",
+		},
+		"not too deep": {
+			[]ConverterOption{WithMaxDepth(3)},
+			`v = {a = {b = {c = 1}}}`,
+			nil,
+			"",
+		},
+		"too deep by default": {
+			nil,
+			`v = {}; local t = v; for i = 1, 200 do t.a = {}; t = t.a end`,
+			cty.GetAttrPath("a"), // prefix of the path to the failing table
+			"tables are nested too deeply; the maximum nesting depth is 100",
+		},
+		"no limit": {
+			[]ConverterOption{WithMaxDepth(0)},
+			`v = {}; local t = v; for i = 1, 200 do t.a = {}; t = t.a end`,
+			nil,
+			"",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L, test.Opts...)
+			if err := L.DoString(test.Src); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			_, err := conv.ToCtyValue(L.GetGlobal("v"), cty.DynamicPseudoType)
+			if test.WantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("conversion succeeded; want error")
+			}
+			if got := err.Error(); got != test.WantErr {
+				t.Errorf("wrong error\ngot:  %s\nwant: %s", got, test.WantErr)
+			}
+			var pathErr cty.PathError
+			if !errors.As(err, &pathErr) {
+				t.Fatalf("error is not a cty.PathError")
+			}
+			if !pathErr.Path.HasPrefix(test.WantPath) {
+				t.Errorf("wrong path\ngot:  %#v\nwant: %#v", pathErr.Path, test.WantPath)
+			}
+		})
+	}
+}
+
+func TestConverterToCtyValueWithOptions(t *testing.T) {
+	objTy := cty.Object(map[string]cty.Type{
+		"name": cty.String,
+	})
+
+	tests := map[string]struct {
+		Src       string
+		Type      cty.Type
+		Opts      *ToCtyOptions
+		Want      cty.Value
+		WantErr   string
+		WantWarns []string
+	}{
+		"nil options": {
+			`v = {name = "a", extra = true}`,
+			objTy,
+			nil,
+			cty.NilVal,
+			`unexpected key "extra"`,
+			nil,
+		},
+		"ignore": {
+			`v = {name = "a", extra = true}`,
+			objTy,
+			&ToCtyOptions{UnexpectedKeys: UnexpectedKeysIgnore},
+			cty.ObjectVal(map[string]cty.Value{
+				"name": cty.StringVal("a"),
+			}),
+			"",
+			nil,
+		},
+		"warn": {
+			`v = {name = "a", extra = true}`,
+			objTy,
+			&ToCtyOptions{UnexpectedKeys: UnexpectedKeysWarn},
+			cty.ObjectVal(map[string]cty.Value{
+				"name": cty.StringVal("a"),
+			}),
+			"",
+			[]string{`unexpected key "extra"`},
+		},
+		"ignore prefix": {
+			`v = {name = "a", _comment = "hi"}`,
+			objTy,
+			&ToCtyOptions{KeyPrefixes: map[string]UnexpectedKeyPolicy{
+				"_": UnexpectedKeysIgnore,
+			}},
+			cty.ObjectVal(map[string]cty.Value{
+				"name": cty.StringVal("a"),
+			}),
+			"",
+			nil,
+		},
+		"prefix does not match": {
+			`v = {name = "a", extra = true}`,
+			objTy,
+			&ToCtyOptions{KeyPrefixes: map[string]UnexpectedKeyPolicy{
+				"_": UnexpectedKeysIgnore,
+			}},
+			cty.NilVal,
+			`unexpected key "extra"`,
+			nil,
+		},
+		"longest prefix wins": {
+			`v = {name = "a", _comment = "hi", __private = 1}`,
+			objTy,
+			&ToCtyOptions{KeyPrefixes: map[string]UnexpectedKeyPolicy{
+				"_":  UnexpectedKeysIgnore,
+				"__": UnexpectedKeysError,
+			}},
+			cty.NilVal,
+			`unexpected key "__private"`,
+			nil,
+		},
+		"ignore in list": {
+			`v = {"a", "b", _comment = "hi"}`,
+			cty.List(cty.String),
+			&ToCtyOptions{KeyPrefixes: map[string]UnexpectedKeyPolicy{
+				"_": UnexpectedKeysIgnore,
+			}},
+			cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}),
+			"",
+			nil,
+		},
+		"warn in tuple": {
+			`v = {"a", "b", "c"}`,
+			cty.Tuple([]cty.Type{cty.String, cty.String}),
+			&ToCtyOptions{UnexpectedKeys: UnexpectedKeysWarn},
+			cty.TupleVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}),
+			"",
+			[]string{"index out of range 3"},
+		},
+		"ignore prefix in implied type": {
+			`v = {name = "a", _comment = "hi"}`,
+			cty.DynamicPseudoType,
+			&ToCtyOptions{KeyPrefixes: map[string]UnexpectedKeyPolicy{
+				"_": UnexpectedKeysIgnore,
+			}},
+			cty.ObjectVal(map[string]cty.Value{
+				"name": cty.StringVal("a"),
+			}),
+			"",
+			nil,
+		},
+		"ignore prefix in implied tuple type": {
+			`v = {"a", _comment = "hi"}`,
+			cty.DynamicPseudoType,
+			&ToCtyOptions{KeyPrefixes: map[string]UnexpectedKeyPolicy{
+				"_": UnexpectedKeysIgnore,
+			}},
+			cty.TupleVal([]cty.Value{cty.StringVal("a")}),
+			"",
+			nil,
+		},
+		"nested": {
+			`v = {items = {{name = "a", _x = 1}}}`,
+			cty.Object(map[string]cty.Type{
+				"items": cty.List(objTy),
+			}),
+			&ToCtyOptions{KeyPrefixes: map[string]UnexpectedKeyPolicy{
+				"_": UnexpectedKeysIgnore,
+			}},
+			cty.ObjectVal(map[string]cty.Value{
+				"items": cty.ListVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{
+						"name": cty.StringVal("a"),
+					}),
+				}),
+			}),
+			"",
+			nil,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L)
+			if err := L.DoString(test.Src); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var warns []string
+			if test.Opts != nil {
+				test.Opts.OnUnexpectedKey = func(err error) {
+					warns = append(warns, err.Error())
+				}
+			}
+			got, err := conv.ToCtyValueWithOptions(L.GetGlobal("v"), test.Type, test.Opts)
+			if test.WantErr != "" {
+				if err == nil {
+					t.Fatalf("conversion succeeded; want error")
+				}
+				if got := err.Error(); got != test.WantErr {
+					t.Errorf("wrong error\ngot:  %s\nwant: %s", got, test.WantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !got.RawEquals(test.Want) {
+				t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, test.Want)
+			}
+			if len(warns) != len(test.WantWarns) {
+				t.Fatalf("wrong warnings\ngot:  %q\nwant: %q", warns, test.WantWarns)
+			}
+			for i := range warns {
+				if warns[i] != test.WantWarns[i] {
+					t.Errorf("wrong warning %d\ngot:  %s\nwant: %s", i, warns[i], test.WantWarns[i])
+				}
+			}
+		})
+	}
+}
+
+func TestConverterExactNumbers(t *testing.T) {
+	tests := map[string]struct {
+		Opts    []ConverterOption
+		Src     string
+		Want    cty.Value
+		WantErr string
+	}{
+		"integer arithmetic": {
+			[]ConverterOption{WithExactNumbers()},
+			`return cty.number(9007199254740992) + 1`,
+			cty.MustParseNumberVal("9007199254740993"),
+			"",
+		},
+		"integer arithmetic without exact numbers": {
+			nil,
+			`return cty.number(9007199254740992) + 1`,
+			cty.MustParseNumberVal("9007199254740992"),
+			"",
+		},
+		"string constructor": {
+			[]ConverterOption{WithExactNumbers()},
+			`return cty.number("12345678901234567890")`,
+			cty.MustParseNumberVal("12345678901234567890"),
+			"",
+		},
+		"string operand": {
+			[]ConverterOption{WithExactNumbers()},
+			`return cty.number(0) + "12345678901234567891"`,
+			cty.MustParseNumberVal("12345678901234567891"),
+			"",
+		},
+		"fraction": {
+			[]ConverterOption{WithExactNumbers()},
+			`return cty.number(0.5) + 0.25`,
+			cty.NumberFloatVal(0.75),
+			"",
+		},
+		"tonative exact": {
+			[]ConverterOption{WithExactNumbers()},
+			`return cty.number("12345"):tonative()`,
+			cty.NumberIntVal(12345),
+			"",
+		},
+		"tonative inexact": {
+			[]ConverterOption{WithExactNumbers()},
+			`return cty.number("12345678901234567891"):tonative()`,
+			cty.NilVal,
+			"number 12345678901234567891 cannot be represented exactly as a Lua number",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L, test.Opts...)
+			conv.PreloadModule("cty")
+			if err := L.DoString(`cty = require("cty")`); err != nil {
+				t.Fatalf("failed to load module: %s", err)
+			}
+
+			err := L.DoString(test.Src)
+			if test.WantErr != "" {
+				opErr, ok := AsOperationError(err)
+				if !ok {
+					t.Fatalf("wrong error %v; want an operation error", err)
+				}
+				if got := opErr.Err.Error(); got != test.WantErr {
+					t.Errorf("wrong error\ngot:  %s\nwant: %s", got, test.WantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			got, err := conv.ToCtyValue(L.Get(-1), cty.Number)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !got.Equals(test.Want).True() {
+				t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, test.Want)
+			}
+		})
+	}
+}
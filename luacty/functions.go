@@ -1,10 +1,12 @@
 package luacty
 
 import (
+	"errors"
 	"fmt"
 
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
 	"github.com/zclconf/go-cty/cty/function"
 )
 
@@ -12,12 +14,23 @@ import (
 // as a Lua function.
 //
 // Arguments to the produced Lua function are converted to the cty types
-// required by the function. The return value is always a cty Value wrapped
-// in a Lua userdata, as would be returned from WrapCtyValue.
+// required by the function. The return value is a cty Value wrapped
+// in a Lua userdata, as would be returned from WrapCtyValue, unless the
+// converter was created with WithNativePrimitives.
+//
+// Errors, including errors converting the arguments, are raised in Lua as
+// OperationError objects. If the error relates to a particular argument
+// then the error object's "arg" field gives its index.
 func (c *Converter) WrapCtyFunction(f function.Function) *lua.LFunction {
+	return c.wrapCtyFunction(f)
+}
+
+// wrapCtyFunction is the implementation of WrapCtyFunction, which also
+// allows giving upvalues for the resulting Lua function.
+func (c *Converter) wrapCtyFunction(f function.Function, upvalues ...lua.LValue) *lua.LFunction {
 	params := f.Params()
 	varParam := f.VarParam()
-	return c.lstate.NewFunction(func(L *lua.LState) int {
+	return c.lstate.NewClosure(func(L *lua.LState) int {
 		nArg := L.GetTop()
 		args := make([]cty.Value, nArg)
 
@@ -27,7 +40,7 @@ func (c *Converter) WrapCtyFunction(f function.Function) *lua.LFunction {
 				param = params[i]
 			} else {
 				if varParam == nil {
-					L.ArgError(i+1, "too many arguments")
+					c.raiseArgError(L, "argument", i+1, errors.New("too many arguments"))
 					return 0
 				}
 				param = *varParam
@@ -36,7 +49,7 @@ func (c *Converter) WrapCtyFunction(f function.Function) *lua.LFunction {
 			vL := L.CheckAny(i + 1)
 			v, err := c.ToCtyValue(vL, param.Type)
 			if err != nil {
-				L.ArgError(i+1, err.Error())
+				c.raiseArgError(L, "argument", i+1, err)
 				return 0
 			}
 			args[i] = v
@@ -44,13 +57,18 @@ func (c *Converter) WrapCtyFunction(f function.Function) *lua.LFunction {
 
 		result, err := f.Call(args)
 		if err != nil {
-			L.Error(lua.LString(err.Error()), 1)
+			arg := 0
+			var argErr function.ArgError
+			if errors.As(err, &argErr) {
+				arg = argErr.Index + 1
+			}
+			c.raiseArgError(L, "call", arg, err)
 			return 0
 		}
 
-		L.Push(c.WrapCtyValue(result))
+		L.Push(c.wrapResult(result))
 		return 1
-	})
+	}, upvalues...)
 }
 
 // ToCtyFunction wraps a Lua function so that it can be used as a cty
@@ -59,7 +77,67 @@ func (c *Converter) WrapCtyFunction(f function.Function) *lua.LFunction {
 // Since Lua functions do not have statically-defined argument types,
 // all of the parameters in the returned function are typed as
 // cty.DynamicPseudoType, and the return type is also cty.DynamicPseudoType.
+//
+// The exception is functions that were created by the "func" function in
+// the Lua module registered by PreloadModule, which declare their parameter
+// and return types:
+//
+//     cty.func{
+//         params = {
+//             {name = "s", type = cty.String},
+//         },
+//         var_param = {name = "rest", type = cty.Number},
+//         returns = cty.String,
+//         impl = function(s, ...)
+//             return s
+//         end,
+//     }
+//
+// Each parameter may also set allow_null, allow_unknown and
+// allow_dynamic_type to true to set the corresponding fields of
+// function.Parameter. Only impl is required: parameter types and the return
+// type default to cty.DynamicPseudoType.
+//
+// Instead of a static return type, a declaration may provide a function as
+// "type", which is called with a sequence table of the argument types and
+// must return the result type, in the same way as the Type callback of a
+// function.Spec:
+//
+//     type = function(argtypes)
+//         return cty.List(argtypes[1])
+//     end,
+//
+// By default only the first value returned by impl is used as the result.
+// A declaration can set "results" to collect all of the returned values
+// instead: either to the string "tuple" to produce a tuple with one element
+// per returned value, or to a sequence of names to produce an object with
+// one attribute per returned value:
+//
+//     results = {"ok", "value"},
+//     impl = function()
+//         return true, "hello"
+//     end,
+//
+// Any declared return type then applies to the tuple or object as a whole.
+//
+// If a wrapped Lua function raises an error with a string message, the
+// resulting Go error is a *LuaError. A Lua function can instead raise an
+// error with a table to describe a problem with a specific argument, which
+// produces a function.ArgError:
+//
+//     error({arg = 2, msg = "must be positive"})
+//
+// The table may also have a "path" field, a sequence of attribute names and
+// zero-based indices, to produce a cty.PathError describing a problem with
+// a specific part of the argument.
+//
+// For these functions, the result is the fully-typed cty function described
+// by the declaration.
 func (c *Converter) ToCtyFunction(f *lua.LFunction) function.Function {
+	if fn, declared := declaredFunction(f); declared {
+		return fn
+	}
+
 	proto := f.Proto
 
 	spec := &function.Spec{
@@ -94,20 +172,360 @@ func (c *Converter) ToCtyFunction(f *lua.LFunction) function.Function {
 	}
 
 	spec.Impl = func(args []cty.Value, retType cty.Type) (cty.Value, error) {
-		for _, arg := range args {
-			c.lstate.Push(c.WrapCtyValue(arg))
+		return c.callLuaFunction(f, args, retType, funcResults{})
+	}
+
+	return function.New(spec)
+}
+
+// moduleFunc implements the "func" function in the Lua module, which
+// allows Lua code to declare a cty function with typed parameters as
+// described for ToCtyFunction.
+//
+// The result is a Lua function that calls the declared function, converting
+// its arguments to the declared types, and which ToCtyFunction recognizes.
+func (c *Converter) moduleFunc(L *lua.LState) int {
+	decl := L.CheckTable(1)
+
+	spec, err := c.funcSpec(decl)
+	if err != nil {
+		L.ArgError(1, err.Error())
+		return 0
+	}
+
+	// The declared function is carried in the result's only upvalue so
+	// that ToCtyFunction can recover it from the Lua function alone.
+	fn := function.New(spec)
+	ud := L.NewUserData()
+	ud.Value = fn
+	L.Push(c.wrapCtyFunction(fn, ud))
+	return 1
+}
+
+// declaredFunction returns the cty function that the given Lua function
+// calls, if the Lua function was created by moduleFunc.
+func declaredFunction(f *lua.LFunction) (function.Function, bool) {
+	if !f.IsG || len(f.Upvalues) != 1 {
+		return function.Function{}, false
+	}
+	ud, isUD := f.Upvalues[0].Value().(*lua.LUserData)
+	if !isUD {
+		return function.Function{}, false
+	}
+	fn, isFn := ud.Value.(function.Function)
+	return fn, isFn
+}
+
+// funcSpec builds a cty function specification from a Lua table in the
+// form accepted by moduleFunc.
+func (c *Converter) funcSpec(decl *lua.LTable) (*function.Spec, error) {
+	spec := &function.Spec{}
+
+	var impl, typeFn *lua.LFunction
+	var results funcResults
+	retType := cty.DynamicPseudoType
+	hasReturns := false
+	var err error
+	decl.ForEach(func(key, value lua.LValue) {
+		if err != nil {
+			return
+		}
+		switch key {
+		case lua.LString("params"):
+			table, isTable := value.(*lua.LTable)
+			if !isTable {
+				err = fmt.Errorf("params must be a sequence of parameter declarations")
+				return
+			}
+			l := table.Len()
+			if countTableKeys(table) != l {
+				err = fmt.Errorf("params must be a sequence of parameter declarations")
+				return
+			}
+			spec.Params = make([]function.Parameter, l)
+			for i := range spec.Params {
+				spec.Params[i], err = c.funcParam(table.RawGetInt(i+1), fmt.Sprintf("arg%d", i+1))
+				if err != nil {
+					err = fmt.Errorf("invalid parameter %d: %s", i+1, err)
+					return
+				}
+			}
+		case lua.LString("var_param"):
+			param, paramErr := c.funcParam(value, "...")
+			if paramErr != nil {
+				err = fmt.Errorf("invalid var_param: %s", paramErr)
+				return
+			}
+			spec.VarParam = &param
+		case lua.LString("returns"):
+			retType, err = c.ToCtyType(value)
+			if err != nil {
+				err = fmt.Errorf("invalid returns: %s", err)
+				return
+			}
+			hasReturns = true
+		case lua.LString("type"):
+			f, isFunc := value.(*lua.LFunction)
+			if !isFunc {
+				err = fmt.Errorf("type must be a function")
+				return
+			}
+			typeFn = f
+		case lua.LString("results"):
+			results, err = c.funcResults(value)
+			if err != nil {
+				err = fmt.Errorf("invalid results: %s", err)
+				return
+			}
+		case lua.LString("impl"):
+			f, isFunc := value.(*lua.LFunction)
+			if !isFunc {
+				err = fmt.Errorf("impl must be a function")
+				return
+			}
+			impl = f
+		default:
+			err = fmt.Errorf("unexpected key %q", key.String())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if impl == nil {
+		return nil, fmt.Errorf("impl is required")
+	}
+
+	if typeFn != nil {
+		if hasReturns {
+			return nil, fmt.Errorf("returns and type cannot both be set")
+		}
+		spec.Type = func(args []cty.Value) (cty.Type, error) {
+			return c.callLuaTypeFunction(typeFn, args)
+		}
+	} else {
+		spec.Type = function.StaticReturnType(retType)
+	}
+	spec.Impl = func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return c.callLuaFunction(impl, args, retType, results)
+	}
+
+	return spec, nil
+}
+
+// funcResults describes how the values returned by a Lua function are
+// combined to produce the result of a cty function.
+type funcResults struct {
+	// Collect is true if all of the returned values should be collected
+	// into a single tuple or object value, rather than using only the
+	// first returned value.
+	Collect bool
+
+	// Names, if Collect is set, gives the attribute names of an object
+	// to collect the returned values into. If it is nil, the values are
+	// collected into a tuple instead.
+	Names []string
+}
+
+// funcResults builds a funcResults from a Lua value in the form accepted
+// for "results" by moduleFunc.
+func (c *Converter) funcResults(val lua.LValue) (funcResults, error) {
+	switch tv := val.(type) {
+	case lua.LString:
+		if tv != "tuple" {
+			return funcResults{}, fmt.Errorf(`must be either "tuple" or a sequence of names`)
+		}
+		return funcResults{Collect: true}, nil
+	case *lua.LTable:
+		l := tv.Len()
+		if countTableKeys(tv) != l {
+			return funcResults{}, fmt.Errorf(`must be either "tuple" or a sequence of names`)
+		}
+		names := make([]string, l)
+		seen := make(map[string]bool, l)
+		for i := range names {
+			name, isStr := tv.RawGetInt(i + 1).(lua.LString)
+			if !isStr {
+				return funcResults{}, fmt.Errorf("result names must be strings")
+			}
+			if seen[string(name)] {
+				return funcResults{}, fmt.Errorf("duplicate result name %q", string(name))
+			}
+			seen[string(name)] = true
+			names[i] = string(name)
+		}
+		return funcResults{Collect: true, Names: names}, nil
+	default:
+		return funcResults{}, fmt.Errorf(`must be either "tuple" or a sequence of names`)
+	}
+}
+
+// funcParam builds a cty function parameter from a Lua table in the form
+// accepted by moduleFunc, using the given default name if the table
+// does not specify one.
+func (c *Converter) funcParam(val lua.LValue, defaultName string) (function.Parameter, error) {
+	table, isTable := val.(*lua.LTable)
+	if !isTable {
+		return function.Parameter{}, fmt.Errorf("a table is required")
+	}
+
+	param := function.Parameter{
+		Name: defaultName,
+		Type: cty.DynamicPseudoType,
+	}
+	var err error
+	table.ForEach(func(key, value lua.LValue) {
+		if err != nil {
+			return
+		}
+		switch key {
+		case lua.LString("name"):
+			name, isStr := value.(lua.LString)
+			if !isStr {
+				err = fmt.Errorf("name must be a string")
+				return
+			}
+			param.Name = string(name)
+		case lua.LString("type"):
+			param.Type, err = c.ToCtyType(value)
+		case lua.LString("allow_null"):
+			param.AllowNull = lua.LVAsBool(value)
+		case lua.LString("allow_unknown"):
+			param.AllowUnknown = lua.LVAsBool(value)
+		case lua.LString("allow_dynamic_type"):
+			param.AllowDynamicType = lua.LVAsBool(value)
+		default:
+			err = fmt.Errorf("unexpected key %q", key.String())
+		}
+	})
+	return param, err
+}
+
+// callLuaFunction calls the given Lua function with the given arguments and
+// converts its results to the given type, as described by the given
+// funcResults.
+func (c *Converter) callLuaFunction(f *lua.LFunction, args []cty.Value, retType cty.Type, results funcResults) (cty.Value, error) {
+	L := c.lstate
+
+	// We might be called while there are already other values on the
+	// stack, so we must read our results relative to where we started.
+	base := L.GetTop()
+	L.Push(f)
+	for _, arg := range args {
+		L.Push(c.wrapResult(arg))
+	}
+	nret := 1
+	if results.Collect {
+		nret = lua.MultRet
+	}
+	err := L.PCall(len(args), nret, nil)
+	if err != nil {
+		return cty.DynamicVal, c.functionError(err, len(args))
+	}
+
+	valuesL := make([]lua.LValue, L.GetTop()-base)
+	for i := range valuesL {
+		valuesL[i] = L.Get(base + i + 1)
+	}
+	L.SetTop(base)
+
+	switch {
+	case !results.Collect:
+		return c.ToCtyValue(valuesL[0], retType)
+	case results.Names != nil:
+		return c.objectFromResults(valuesL, results.Names, retType)
+	default:
+		return c.tupleFromResults(valuesL, retType)
+	}
+}
+
+// objectFromResults converts the given values returned from a Lua function
+// into an object with the given attribute names, and then into the given
+// type.
+func (c *Converter) objectFromResults(valuesL []lua.LValue, names []string, retType cty.Type) (cty.Value, error) {
+	if len(valuesL) > len(names) {
+		return cty.DynamicVal, fmt.Errorf("function returned %d values, but only %d result names are declared", len(valuesL), len(names))
+	}
+
+	table := c.lstate.NewTable()
+	atys := make(map[string]cty.Type, len(names))
+	for i, name := range names {
+		valueL := lua.LValue(lua.LNil)
+		if i < len(valuesL) {
+			valueL = valuesL[i]
 		}
-		err := c.lstate.PCall(len(args), 1, f)
+		table.RawSetString(name, valueL)
+		aty, err := c.impliedCtyType(&toCtyState{}, valueL, cty.GetAttrPath(name))
 		if err != nil {
 			return cty.DynamicVal, err
 		}
-		resultL := c.lstate.CheckAny(1)
-		result, err := c.ToCtyValue(resultL, retType)
+		atys[name] = aty
+	}
+
+	ty := retType
+	if ty == cty.DynamicPseudoType {
+		ty = cty.Object(atys)
+	}
+	return c.ToCtyValue(table, ty)
+}
+
+// tupleFromResults converts the given values returned from a Lua function
+// into a tuple, and then into the given type.
+func (c *Converter) tupleFromResults(valuesL []lua.LValue, retType cty.Type) (cty.Value, error) {
+	var etys []cty.Type
+	if retType.IsTupleType() {
+		etys = retType.TupleElementTypes()
+		if len(valuesL) > len(etys) {
+			return cty.DynamicVal, fmt.Errorf("function returned %d values, but the result type has only %d elements", len(valuesL), len(etys))
+		}
+	} else {
+		etys = make([]cty.Type, len(valuesL))
+		for i := range etys {
+			etys[i] = cty.DynamicPseudoType
+		}
+	}
+
+	elems := make([]cty.Value, len(etys))
+	for i, ety := range etys {
+		valueL := lua.LValue(lua.LNil)
+		if i < len(valuesL) {
+			valueL = valuesL[i]
+		}
+		path := cty.IndexPath(cty.NumberIntVal(int64(i)))
+		ev, err := c.toCtyValue(&toCtyState{}, valueL, ety, path)
 		if err != nil {
 			return cty.DynamicVal, err
 		}
-		return result, nil
+		elems[i] = ev
 	}
 
-	return function.New(spec)
+	result, err := convert.Convert(cty.TupleVal(elems), retType)
+	if err != nil {
+		return cty.DynamicVal, err
+	}
+	return result, nil
+}
+
+// callLuaTypeFunction calls the given Lua function with a sequence table of
+// the types of the given arguments, and returns the type that it returns.
+func (c *Converter) callLuaTypeFunction(f *lua.LFunction, args []cty.Value) (cty.Type, error) {
+	L := c.lstate
+	argTypes := L.NewTable()
+	for _, arg := range args {
+		argTypes.Append(c.WrapCtyType(arg.Type()))
+	}
+
+	L.Push(f)
+	L.Push(argTypes)
+	err := L.PCall(1, 1, nil)
+	if err != nil {
+		return cty.DynamicPseudoType, c.functionError(err, len(args))
+	}
+
+	resultL := L.Get(-1)
+	L.Pop(1)
+	ty, err := c.ToCtyType(resultL)
+	if err != nil {
+		return cty.DynamicPseudoType, fmt.Errorf("invalid result from type function: %s", err)
+	}
+	return ty, nil
 }
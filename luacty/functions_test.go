@@ -1,6 +1,7 @@
 package luacty
 
 import (
+	"fmt"
 	"testing"
 
 	lua "github.com/yuin/gopher-lua"
@@ -65,3 +66,400 @@ func TestConverterWrapCtyFunction(t *testing.T) {
 	}
 
 }
+
+func TestConverterToCtyFunctionDeclared(t *testing.T) {
+	L := lua.NewState()
+	conv := NewConverter(L)
+	conv.PreloadModule("cty")
+	if err := L.DoString(`cty = require("cty")`); err != nil {
+		t.Fatalf("failed to load module: %s", err)
+	}
+	addTestFuncs(L, t)
+
+	err := L.DoString(`
+		repeat_str = cty.func{
+			params = {
+				{name = "s", type = cty.String},
+				{name = "n", type = "number"},
+			},
+			var_param = {name = "suffixes", type = cty.String},
+			returns = cty.String,
+			impl = function(s, n, ...)
+				local result = ""
+				for i = 1, n:tonative() do
+					result = result .. s:tonative()
+				end
+				for _, suffix in ipairs({...}) do
+					result = result .. suffix:tonative()
+				end
+				return result
+			end,
+		}
+
+		-- the declared function can also be called directly from Lua
+		assert(repeat_str("a", 2) == cty.string("aa"))
+	`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	f := conv.ToCtyFunction(L.GetGlobal("repeat_str").(*lua.LFunction))
+
+	params := f.Params()
+	if got, want := len(params), 2; got != want {
+		t.Fatalf("wrong number of parameters %d; want %d", got, want)
+	}
+	if got, want := params[0].Name, "s"; got != want {
+		t.Errorf("wrong name for first parameter %q; want %q", got, want)
+	}
+	if got, want := params[1].Type, cty.Number; !got.Equals(want) {
+		t.Errorf("wrong type for second parameter %#v; want %#v", got, want)
+	}
+	if varParam := f.VarParam(); varParam == nil || varParam.Name != "suffixes" {
+		t.Errorf("wrong var_param %#v", varParam)
+	}
+
+	retTy, err := f.ReturnType([]cty.Type{cty.String, cty.Number})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !retTy.Equals(cty.String) {
+		t.Errorf("wrong return type %#v; want %#v", retTy, cty.String)
+	}
+
+	got, err := f.Call([]cty.Value{
+		cty.StringVal("ab"),
+		cty.NumberIntVal(2),
+		cty.StringVal("!"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := cty.StringVal("abab!"); !got.RawEquals(want) {
+		t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, want)
+	}
+
+	// Arguments are type-checked before the Lua implementation is called.
+	_, err = f.Call([]cty.Value{
+		cty.StringVal("ab"),
+		cty.StringVal("not a number"),
+	})
+	if err == nil {
+		t.Errorf("call with invalid argument succeeded; want error")
+	}
+}
+
+func TestConverterToCtyFunctionDeclaredRepeatedly(t *testing.T) {
+	L := lua.NewState()
+	conv := NewConverter(L)
+	conv.PreloadModule("cty")
+	if err := L.DoString(`cty = require("cty")`); err != nil {
+		t.Fatalf("failed to load module: %s", err)
+	}
+
+	// Each evaluation of a declaration produces a new function, which
+	// ToCtyFunction must recognize without the converter retaining it.
+	src := `return cty.func{
+		params = {{name = "n", type = cty.Number}},
+		returns = cty.String,
+		impl = function(n) return n end,
+	}`
+	for i := 0; i < 100; i++ {
+		if err := L.DoString(src); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		f := conv.ToCtyFunction(L.Get(-1).(*lua.LFunction))
+		L.Pop(1)
+
+		params := f.Params()
+		if len(params) != 1 || params[0].Name != "n" || !params[0].Type.Equals(cty.Number) {
+			t.Fatalf("wrong parameters %#v", params)
+		}
+		got, err := f.Call([]cty.Value{cty.NumberIntVal(int64(i))})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if want := cty.StringVal(fmt.Sprint(i)); !got.RawEquals(want) {
+			t.Fatalf("wrong result\ngot:  %#v\nwant: %#v", got, want)
+		}
+	}
+}
+
+func TestConverterToCtyFunctionDeclaredErrors(t *testing.T) {
+	tests := map[string]string{
+		"missing impl":       `cty.func{}`,
+		"impl not function":  `cty.func{impl = "hello"}`,
+		"unexpected key":     `cty.func{impl = function() end, extra = true}`,
+		"params not table":   `cty.func{impl = function() end, params = "s"}`,
+		"params not seq":     `cty.func{impl = function() end, params = {s = {}}}`,
+		"invalid param type": `cty.func{impl = function() end, params = {{type = "strin"}}}`,
+		"invalid param key":  `cty.func{impl = function() end, params = {{kind = "string"}}}`,
+		"invalid returns":    `cty.func{impl = function() end, returns = 5}`,
+		"type not function":  `cty.func{impl = function() end, type = cty.String}`,
+		"returns and type":   `cty.func{impl = function() end, returns = cty.String, type = function() end}`,
+		"invalid results":    `cty.func{impl = function() end, results = "list"}`,
+		"duplicate results":  `cty.func{impl = function() end, results = {"a", "a"}}`,
+	}
+
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L)
+			conv.PreloadModule("cty")
+			if err := L.DoString(`cty = require("cty")`); err != nil {
+				t.Fatalf("failed to load module: %s", err)
+			}
+
+			err := L.DoString(src)
+			if err == nil {
+				t.Errorf("declaration succeeded; want error")
+			}
+		})
+	}
+}
+
+func TestConverterToCtyFunctionTypeCallback(t *testing.T) {
+	L := lua.NewState()
+	conv := NewConverter(L)
+	conv.PreloadModule("cty")
+	if err := L.DoString(`cty = require("cty")`); err != nil {
+		t.Fatalf("failed to load module: %s", err)
+	}
+	addTestFuncs(L, t)
+
+	err := L.DoString(`
+		pair = cty.func{
+			params = {
+				{name = "a"},
+				{name = "b"},
+			},
+			type = function(argtypes)
+				if argtypes[1] ~= argtypes[2] then
+					error("arguments must have the same type")
+				end
+				return cty.List(argtypes[1])
+			end,
+			impl = function(a, b)
+				return cty.list{a, b}
+			end,
+		}
+		broken = cty.func{
+			type = function(argtypes)
+				return "not a type("
+			end,
+			impl = function() end,
+		}
+	`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	f := conv.ToCtyFunction(L.GetGlobal("pair").(*lua.LFunction))
+
+	retTy, err := f.ReturnType([]cty.Type{cty.String, cty.String})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := cty.List(cty.String); !retTy.Equals(want) {
+		t.Errorf("wrong return type %#v; want %#v", retTy, want)
+	}
+
+	_, err = f.ReturnType([]cty.Type{cty.String, cty.Number})
+	if err == nil {
+		t.Errorf("type check of mismatched arguments succeeded; want error")
+	}
+
+	got, err := f.Call([]cty.Value{cty.StringVal("a"), cty.StringVal("b")})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")})
+	if !got.RawEquals(want) {
+		t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, want)
+	}
+
+	// With an unknown argument, the result is an unknown value of the
+	// type returned by the type callback, without calling impl.
+	got, err = f.Call([]cty.Value{cty.StringVal("a"), cty.UnknownVal(cty.String)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want = cty.UnknownVal(cty.List(cty.String))
+	if !got.RawEquals(want) {
+		t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, want)
+	}
+
+	broken := conv.ToCtyFunction(L.GetGlobal("broken").(*lua.LFunction))
+	_, err = broken.ReturnType(nil)
+	if err == nil {
+		t.Errorf("type check with invalid type callback succeeded; want error")
+	}
+}
+
+func TestConverterToCtyFunction(t *testing.T) {
+	L := lua.NewState()
+	conv := NewConverter(L)
+
+	err := L.DoString(`
+		function greet(name)
+			return "Hello, " .. name:tonative() .. "!"
+		end
+	`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	f := conv.ToCtyFunction(L.GetGlobal("greet").(*lua.LFunction))
+	if got, want := len(f.Params()), 1; got != want {
+		t.Fatalf("wrong number of parameters %d; want %d", got, want)
+	}
+
+	// Other values already on the stack must not interfere with reading
+	// the function's result.
+	L.Push(lua.LString("unrelated"))
+	top := L.GetTop()
+
+	got, err := f.Call([]cty.Value{cty.StringVal("Lua")})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := cty.StringVal("Hello, Lua!"); !got.RawEquals(want) {
+		t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, want)
+	}
+	if L.GetTop() != top {
+		t.Errorf("stack has %d values after call; want %d", L.GetTop(), top)
+	}
+}
+
+func TestConverterToCtyFunctionResults(t *testing.T) {
+	tests := map[string]struct {
+		Decl string
+		Args []cty.Value
+		Want cty.Value
+		Err  bool
+	}{
+		"first result only": {
+			`cty.func{
+				impl = function() return "a", "b" end,
+			}`,
+			nil,
+			cty.StringVal("a"),
+			false,
+		},
+		"tuple": {
+			`cty.func{
+				results = "tuple",
+				impl = function() return true, "b" end,
+			}`,
+			nil,
+			cty.TupleVal([]cty.Value{cty.True, cty.StringVal("b")}),
+			false,
+		},
+		"tuple (no results)": {
+			`cty.func{
+				results = "tuple",
+				impl = function() end,
+			}`,
+			nil,
+			cty.EmptyTupleVal,
+			false,
+		},
+		"tuple with declared type": {
+			`cty.func{
+				results = "tuple",
+				returns = "tuple([bool, number])",
+				impl = function() return true, "12" end,
+			}`,
+			nil,
+			cty.TupleVal([]cty.Value{cty.True, cty.NumberIntVal(12)}),
+			false,
+		},
+		"tuple converted to list": {
+			`cty.func{
+				results = "tuple",
+				returns = cty.List(cty.String),
+				impl = function() return "a", "b" end,
+			}`,
+			nil,
+			cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}),
+			false,
+		},
+		"tuple with too many results": {
+			`cty.func{
+				results = "tuple",
+				returns = "tuple([bool])",
+				impl = function() return true, "b" end,
+			}`,
+			nil,
+			cty.DynamicVal,
+			true, // function returned 2 values, but the result type has only 1 elements
+		},
+		"object": {
+			`cty.func{
+				params = {{name = "s"}},
+				results = {"ok", "value"},
+				impl = function(s) return true, s end,
+			}`,
+			[]cty.Value{cty.StringVal("hello")},
+			cty.ObjectVal(map[string]cty.Value{
+				"ok":    cty.True,
+				"value": cty.StringVal("hello"),
+			}),
+			false,
+		},
+		"object (missing results)": {
+			`cty.func{
+				results = {"ok", "value"},
+				returns = "object({ok=bool, value=string})",
+				impl = function() return false end,
+			}`,
+			nil,
+			cty.ObjectVal(map[string]cty.Value{
+				"ok":    cty.False,
+				"value": cty.NullVal(cty.String),
+			}),
+			false,
+		},
+		"object with too many results": {
+			`cty.func{
+				results = {"ok"},
+				impl = function() return true, "b" end,
+			}`,
+			nil,
+			cty.DynamicVal,
+			true, // function returned 2 values, but only 1 result names are declared
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L)
+			conv.PreloadModule("cty")
+			if err := L.DoString(`cty = require("cty")`); err != nil {
+				t.Fatalf("failed to load module: %s", err)
+			}
+
+			if err := L.DoString("f = " + test.Decl); err != nil {
+				t.Fatalf("invalid declaration: %s", err)
+			}
+			f := conv.ToCtyFunction(L.GetGlobal("f").(*lua.LFunction))
+
+			got, err := f.Call(test.Args)
+			if (err != nil) != test.Err {
+				if test.Err {
+					t.Errorf("call succeeded; want error")
+				} else {
+					t.Errorf("unexpected error: %s", err)
+				}
+			}
+			if err != nil {
+				return
+			}
+
+			if !got.RawEquals(test.Want) {
+				t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, test.Want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package luacty
+
+import (
+	"fmt"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// ctyPairs implements the "pairs" function in the Lua module, which returns
+// an iterator over all of the elements of a wrapped collection or structural
+// value, for use with Lua's generic "for" statement.
+//
+// The iterator produces a key and a value for each element, in the same
+// order as cty.Value.ElementIterator. Lists and tuples produce their
+// zero-based indices as keys, maps produce their keys, objects produce
+// their attribute names, and sets produce each element as both the key
+// and the value.
+//
+// We can't use Lua's __pairs metamethod for this because GopherLua
+// implements Lua 5.1, where pairs accepts only tables.
+func (c *Converter) ctyPairs(L *lua.LState) int {
+	v := c.checkValue(L, 1)
+	if err := c.checkIterable(v); err != nil {
+		c.raiseArgError(L, "argument", 1, err)
+		return 0
+	}
+
+	// Marks on the collection as a whole apply to each of its elements.
+	v, marks := v.Unmark()
+	it := v.ElementIterator()
+	L.Push(L.NewFunction(func(L *lua.LState) int {
+		if !it.Next() {
+			L.Push(lua.LNil)
+			return 1
+		}
+		k, ev := it.Element()
+		L.Push(c.wrapResult(k.WithMarks(marks)))
+		L.Push(c.wrapResult(ev.WithMarks(marks)))
+		return 2
+	}))
+	return 1
+}
+
+// ctyIPairs implements the "ipairs" function in the Lua module, which is
+// similar to ctyPairs but accepts only sequence-like values -- lists, tuples
+// and sets -- and always produces zero-based indices as keys.
+func (c *Converter) ctyIPairs(L *lua.LState) int {
+	v := c.checkValue(L, 1)
+	if err := c.checkIterable(v); err != nil {
+		c.raiseArgError(L, "argument", 1, err)
+		return 0
+	}
+	ty := v.Type()
+	if !(ty.IsListType() || ty.IsTupleType() || ty.IsSetType()) {
+		c.raiseArgError(L, "argument", 1, fmt.Errorf("can't iterate over %s with ipairs; use pairs instead", ty.FriendlyName()))
+		return 0
+	}
+
+	v, marks := v.Unmark()
+	it := v.ElementIterator()
+	i := 0
+	L.Push(L.NewFunction(func(L *lua.LState) int {
+		if !it.Next() {
+			L.Push(lua.LNil)
+			return 1
+		}
+		_, ev := it.Element()
+		L.Push(c.wrapResult(cty.NumberIntVal(int64(i))))
+		L.Push(c.wrapResult(ev.WithMarks(marks)))
+		i++
+		return 2
+	}))
+	return 1
+}
+
+// checkIterable returns an error if the given value cannot be iterated over
+// by ctyPairs or ctyIPairs.
+func (c *Converter) checkIterable(v cty.Value) error {
+	ty := v.Type()
+	switch {
+	case !(ty.IsCollectionType() || ty.IsObjectType() || ty.IsTupleType()):
+		return fmt.Errorf("can't iterate over %s", ty.FriendlyName())
+	case !v.IsKnown():
+		return fmt.Errorf("can't iterate over an unknown value")
+	case v.IsNull():
+		return fmt.Errorf("can't iterate over a null value")
+	}
+	return nil
+}
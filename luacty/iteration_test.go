@@ -0,0 +1,192 @@
+package luacty
+
+import (
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestConverterPairs(t *testing.T) {
+	tests := map[string]struct {
+		Vals   map[string]cty.Value
+		Assert string
+	}{
+		"list": {
+			map[string]cty.Value{
+				"a": cty.ListVal([]cty.Value{
+					cty.StringVal("x"),
+					cty.StringVal("y"),
+				}),
+			},
+			`
+				local keys, vals = {}, {}
+				for k, v in cty.pairs(a) do
+					keys[#keys+1] = k:tonative()
+					vals[#vals+1] = v:tonative()
+				end
+				assert(#keys == 2)
+				assert(keys[1] == 0 and keys[2] == 1)
+				assert(vals[1] == "x" and vals[2] == "y")
+			`,
+		},
+		"map": {
+			map[string]cty.Value{
+				"a": cty.MapVal(map[string]cty.Value{
+					"b": cty.NumberIntVal(2),
+					"a": cty.NumberIntVal(1),
+				}),
+			},
+			`
+				local keys, vals = {}, {}
+				for k, v in cty.pairs(a) do
+					keys[#keys+1] = k:tonative()
+					vals[#vals+1] = v:tonative()
+				end
+				assert(#keys == 2)
+				assert(keys[1] == "a" and keys[2] == "b")
+				assert(vals[1] == 1 and vals[2] == 2)
+			`,
+		},
+		"object": {
+			map[string]cty.Value{
+				"a": cty.ObjectVal(map[string]cty.Value{
+					"name": cty.StringVal("web"),
+					"port": cty.NumberIntVal(80),
+				}),
+			},
+			`
+				local keys = {}
+				for k, v in cty.pairs(a) do
+					keys[#keys+1] = k:tonative()
+				end
+				assert(#keys == 2)
+				assert(keys[1] == "name" and keys[2] == "port")
+			`,
+		},
+		"set": {
+			map[string]cty.Value{
+				"a": cty.SetVal([]cty.Value{
+					cty.StringVal("y"),
+					cty.StringVal("x"),
+				}),
+			},
+			`
+				local keys, vals = {}, {}
+				for k, v in cty.pairs(a) do
+					keys[#keys+1] = k:tonative()
+					vals[#vals+1] = v:tonative()
+				end
+				assert(#vals == 2)
+				assert(keys[1] == "x" and vals[1] == "x")
+				assert(keys[2] == "y" and vals[2] == "y")
+			`,
+		},
+		"ipairs over set": {
+			map[string]cty.Value{
+				"a": cty.SetVal([]cty.Value{
+					cty.StringVal("y"),
+					cty.StringVal("x"),
+				}),
+			},
+			`
+				local keys, vals = {}, {}
+				for i, v in cty.ipairs(a) do
+					keys[#keys+1] = i:tonative()
+					vals[#vals+1] = v:tonative()
+				end
+				assert(keys[1] == 0 and vals[1] == "x")
+				assert(keys[2] == 1 and vals[2] == "y")
+			`,
+		},
+		"ipairs over tuple": {
+			map[string]cty.Value{
+				"a": cty.TupleVal([]cty.Value{
+					cty.StringVal("x"),
+					cty.True,
+				}),
+			},
+			`
+				local count = 0
+				for i, v in cty.ipairs(a) do
+					count = count + 1
+				end
+				assert(count == 2)
+			`,
+		},
+		"empty list": {
+			map[string]cty.Value{
+				"a": cty.ListValEmpty(cty.String),
+			},
+			`
+				local count = 0
+				for k, v in cty.pairs(a) do
+					count = count + 1
+				end
+				assert(count == 0)
+			`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L)
+			conv.PreloadModule("cty")
+			if err := L.DoString(`cty = require("cty")`); err != nil {
+				t.Fatalf("failed to load module: %s", err)
+			}
+			addTestFuncs(L, t)
+
+			for n, v := range test.Vals {
+				L.SetGlobal(n, conv.WrapCtyValue(v))
+			}
+
+			err := L.DoString(test.Assert)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestConverterPairsErrors(t *testing.T) {
+	tests := map[string]struct {
+		Val cty.Value
+		Src string
+	}{
+		"pairs over string": {
+			cty.StringVal("hello"),
+			`for k, v in cty.pairs(a) do end`,
+		},
+		"pairs over unknown": {
+			cty.UnknownVal(cty.List(cty.String)),
+			`for k, v in cty.pairs(a) do end`,
+		},
+		"pairs over null": {
+			cty.NullVal(cty.Map(cty.String)),
+			`for k, v in cty.pairs(a) do end`,
+		},
+		"ipairs over map": {
+			cty.MapValEmpty(cty.String),
+			`for k, v in cty.ipairs(a) do end`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L)
+			conv.PreloadModule("cty")
+			if err := L.DoString(`cty = require("cty")`); err != nil {
+				t.Fatalf("failed to load module: %s", err)
+			}
+			L.SetGlobal("a", conv.WrapCtyValue(test.Val))
+
+			err := L.DoString(test.Src)
+			if err == nil {
+				t.Errorf("iteration succeeded; want error")
+			}
+		})
+	}
+}
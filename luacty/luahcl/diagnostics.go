@@ -0,0 +1,239 @@
+// Package luahcl integrates luacty with HCL, describing the errors returned
+// by luacty and by running Lua code in GopherLua as HCL diagnostics.
+//
+// This allows applications that already report problems using the HCL
+// diagnostics model to report problems in Lua scripts in the same way.
+//
+// Errors raised while Lua code is running carry the position where they
+// were raised, and so their diagnostics point into the Lua source. Errors
+// from converting Lua values to cty, however, cannot be located in the Lua
+// source: GopherLua does not record where a table was constructed, so the
+// best this package can do is describe the path of the offending value
+// within the result, such as .servers[2].port. Callers should therefore
+// pass a subject range that gives the best location they know, such as the
+// range returned by FunctionRange for the function whose result could not
+// be converted.
+package luahcl
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/gopherlua-cty/luacty"
+)
+
+// Diagnostics returns HCL diagnostics describing the given error, which
+// would typically be returned by a luacty conversion function such as
+// ToCtyValue, by calling a function produced by ToCtyFunction, or by running
+// Lua code using a lua.LState method such as DoString.
+//
+// Errors raised by Lua code have a subject range pointing at the line of the
+// Lua source where the error was raised. Lua reports only line numbers, so
+// the range covers only the start of that line and its byte offsets are
+// zero.
+//
+// Other errors, such as conversion errors, don't carry their own source
+// location and so the given subject is used instead. In particular, the
+// diagnostic for a value that could not be converted never points at the
+// Lua table it came from, but only describes its path within the result.
+// The subject may be nil if the caller doesn't know where the problem
+// originated. FunctionRange is useful for producing a subject that points
+// at a Lua function whose result could not be converted.
+//
+// If err is nil then the result is also nil.
+func Diagnostics(err error, subject *hcl.Range) hcl.Diagnostics {
+	if err == nil {
+		return nil
+	}
+
+	var opErr *luacty.OperationError
+	if errors.As(err, &opErr) {
+		return hcl.Diagnostics{operationDiagnostic(opErr, subject)}
+	}
+	if opErr, ok := luacty.AsOperationError(err); ok {
+		return hcl.Diagnostics{operationDiagnostic(opErr, subject)}
+	}
+
+	if luaErr, ok := luacty.AsLuaError(err); ok {
+		rng := subject
+		if luaErr.File != "" {
+			rng = lineRange(luaErr.File, luaErr.Line, 1)
+		}
+		return hcl.Diagnostics{
+			diagnostic("Error in Lua script", luaErr.Message, rng),
+		}
+	}
+
+	var apiErr *lua.ApiError
+	if errors.As(err, &apiErr) && apiErr.Type == lua.ApiErrorSyntax {
+		return hcl.Diagnostics{syntaxDiagnostic(apiErr, subject)}
+	}
+
+	var argErr function.ArgError
+	if errors.As(err, &argErr) {
+		detail := argErr.Error()
+		var pathErr cty.PathError
+		if errors.As(err, &pathErr) && len(pathErr.Path) > 0 {
+			detail = fmt.Sprintf("Invalid value at %s: %s", FormatPath(pathErr.Path), detail)
+		}
+		return hcl.Diagnostics{
+			diagnostic(
+				"Invalid function argument",
+				fmt.Sprintf("Invalid value for argument %d: %s", argErr.Index+1, detail),
+				subject,
+			),
+		}
+	}
+
+	var pathErr cty.PathError
+	if errors.As(err, &pathErr) {
+		detail := fmt.Sprintf("The value is not valid: %s", pathErr.Error())
+		if len(pathErr.Path) > 0 {
+			detail = fmt.Sprintf("The value at %s is not valid: %s", FormatPath(pathErr.Path), pathErr.Error())
+		}
+		return hcl.Diagnostics{
+			diagnostic("Invalid value from Lua", detail, subject),
+		}
+	}
+
+	return hcl.Diagnostics{
+		diagnostic("Lua error", err.Error(), subject),
+	}
+}
+
+// ErrorsDiagnostics is like Diagnostics but describes a number of errors
+// together, such as those returned by ToCtyValueAllErrors. The resulting
+// diagnostics are in the same order as the given errors.
+func ErrorsDiagnostics(errs []error, subject *hcl.Range) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	for _, err := range errs {
+		diags = diags.Extend(Diagnostics(err, subject))
+	}
+	return diags
+}
+
+// FunctionRange returns a range covering the lines where the given Lua
+// function is defined, or nil if the function is not a Lua function.
+//
+// As with Diagnostics, the range contains only line numbers.
+func FunctionRange(f *lua.LFunction) *hcl.Range {
+	if f == nil || f.IsG || f.Proto == nil {
+		return nil
+	}
+	rng := lineRange(f.Proto.SourceName, f.Proto.LineDefined, 1)
+	if f.Proto.LastLineDefined > f.Proto.LineDefined {
+		rng.End.Line = f.Proto.LastLineDefined
+	}
+	return rng
+}
+
+// FormatPath returns a string representation of the given path using the
+// attribute and index syntax of HCL, such as .servers[2].port.
+func FormatPath(path cty.Path) string {
+	var buf strings.Builder
+	for _, step := range path {
+		switch step := step.(type) {
+		case cty.GetAttrStep:
+			if isIdentifier(step.Name) {
+				buf.WriteString("." + step.Name)
+			} else {
+				fmt.Fprintf(&buf, "[%q]", step.Name)
+			}
+		case cty.IndexStep:
+			key := step.Key
+			switch {
+			case !key.IsKnown() || key.IsNull() || key.IsMarked():
+				buf.WriteString("[...]")
+			case key.Type() == cty.Number:
+				fmt.Fprintf(&buf, "[%s]", key.AsBigFloat().Text('f', -1))
+			case key.Type() == cty.String:
+				fmt.Fprintf(&buf, "[%q]", key.AsString())
+			default:
+				buf.WriteString("[...]")
+			}
+		}
+	}
+	return buf.String()
+}
+
+func operationDiagnostic(err *luacty.OperationError, subject *hcl.Range) *hcl.Diagnostic {
+	rng := subject
+	if match := operationErrorPosition.FindStringSubmatch(err.Pos); match != nil {
+		line, _ := strconv.Atoi(match[2])
+		rng = lineRange(match[1], line, 1)
+	}
+
+	detail := err.Err.Error()
+	var pathErr cty.PathError
+	if errors.As(err.Err, &pathErr) && len(pathErr.Path) > 0 {
+		detail = fmt.Sprintf("Invalid value at %s: %s", FormatPath(pathErr.Path), detail)
+	}
+	if err.Arg > 0 {
+		detail = fmt.Sprintf("Invalid value for argument %d: %s", err.Arg, detail)
+	}
+	return diagnostic("Invalid operation in Lua script", detail, rng)
+}
+
+func syntaxDiagnostic(err *lua.ApiError, subject *hcl.Range) *hcl.Diagnostic {
+	msg := strings.TrimSpace(err.Object.String())
+	match := syntaxErrorPosition.FindStringSubmatch(msg)
+	if match == nil {
+		return diagnostic("Lua syntax error", msg, subject)
+	}
+	line, _ := strconv.Atoi(match[2])
+	col, _ := strconv.Atoi(match[3])
+	// GopherLua pads its syntax error messages with extra spaces
+	detail := strings.Join(strings.Fields(match[4]), " ")
+	return diagnostic("Lua syntax error", detail, lineRange(match[1], line, col))
+}
+
+// operationErrorPosition matches the position recorded in the Pos field of
+// an OperationError.
+var operationErrorPosition = regexp.MustCompile(`^(.+):(\d+):$`)
+
+// syntaxErrorPosition matches the position prefix of the syntax errors
+// reported by GopherLua's parser.
+var syntaxErrorPosition = regexp.MustCompile(`(?s)^(.+) line:(\d+)\(column:(\d+)\) (.*)$`)
+
+func diagnostic(summary, detail string, subject *hcl.Range) *hcl.Diagnostic {
+	if !strings.HasSuffix(detail, ".") {
+		detail += "."
+	}
+	return &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  summary,
+		Detail:   detail,
+		Subject:  subject,
+	}
+}
+
+func lineRange(filename string, line, column int) *hcl.Range {
+	pos := hcl.Pos{Line: line, Column: column}
+	return &hcl.Range{
+		Filename: filename,
+		Start:    pos,
+		End:      pos,
+	}
+}
+
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+		case i > 0 && (r == '-' || (r >= '0' && r <= '9')):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,238 @@
+package luahcl
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/gopherlua-cty/luacty"
+)
+
+func TestDiagnostics(t *testing.T) {
+	subject := &hcl.Range{
+		Filename: "config.lua",
+		Start:    hcl.Pos{Line: 1, Column: 1},
+		End:      hcl.Pos{Line: 1, Column: 1},
+	}
+
+	tests := map[string]struct {
+		Err         func(L *lua.LState, conv *luacty.Converter) error
+		WantSummary string
+		WantDetail  string
+		WantSubject *hcl.Range
+	}{
+		"nil": {
+			func(L *lua.LState, conv *luacty.Converter) error {
+				return nil
+			},
+			"",
+			"",
+			nil,
+		},
+		"conversion error": {
+			func(L *lua.LState, conv *luacty.Converter) error {
+				if err := L.DoString(`v = {servers = {{}, {}, {port = "http"}}}`); err != nil {
+					t.Fatal(err)
+				}
+				_, err := conv.ToCtyValue(L.GetGlobal("v"), cty.Object(map[string]cty.Type{
+					"servers": cty.List(cty.Object(map[string]cty.Type{
+						"port": cty.Number,
+					})),
+				}))
+				return err
+			},
+			"Invalid value from Lua",
+			"The value at .servers[2].port is not valid: a number is required.",
+			subject,
+		},
+		"conversion error at root": {
+			func(L *lua.LState, conv *luacty.Converter) error {
+				_, err := conv.ToCtyValue(lua.LString("hello"), cty.EmptyObject)
+				return err
+			},
+			"Invalid value from Lua",
+			"The value is not valid: a table is required.",
+			subject,
+		},
+		"runtime error": {
+			func(L *lua.LState, conv *luacty.Converter) error {
+				return L.DoString("local a = 1\nerror(\"oh no\")")
+			},
+			"Error in Lua script",
+			"oh no.",
+			lineRange("<string>", 2, 1),
+		},
+		"function error": {
+			func(L *lua.LState, conv *luacty.Converter) error {
+				if err := L.DoString("f = function()\n  error(\"oh no\")\nend"); err != nil {
+					t.Fatal(err)
+				}
+				f := conv.ToCtyFunction(L.GetGlobal("f").(*lua.LFunction))
+				_, err := f.Call(nil)
+				return err
+			},
+			"Error in Lua script",
+			"oh no.",
+			lineRange("<string>", 2, 1),
+		},
+		"function argument error": {
+			func(L *lua.LState, conv *luacty.Converter) error {
+				if err := L.DoString(`f = function(a, b) error({arg = 2, msg = "must be positive"}) end`); err != nil {
+					t.Fatal(err)
+				}
+				f := conv.ToCtyFunction(L.GetGlobal("f").(*lua.LFunction))
+				_, err := f.Call([]cty.Value{cty.Zero, cty.Zero})
+				return err
+			},
+			"Invalid function argument",
+			"Invalid value for argument 2: must be positive.",
+			subject,
+		},
+		"function argument path error": {
+			func(L *lua.LState, conv *luacty.Converter) error {
+				if err := L.DoString(`f = function(a, b) error({arg = 2, path = {"servers", 2, "port"}, msg = "bad port"}) end`); err != nil {
+					t.Fatal(err)
+				}
+				f := conv.ToCtyFunction(L.GetGlobal("f").(*lua.LFunction))
+				_, err := f.Call([]cty.Value{cty.Zero, cty.Zero})
+				return err
+			},
+			"Invalid function argument",
+			"Invalid value for argument 2: Invalid value at .servers[2].port: bad port.",
+			subject,
+		},
+		"operation error": {
+			func(L *lua.LState, conv *luacty.Converter) error {
+				L.SetGlobal("n", conv.WrapCtyValue(cty.Zero))
+				return L.DoString("\nreturn n + {}")
+			},
+			"Invalid operation in Lua script",
+			"number required.",
+			lineRange("<string>", 2, 1),
+		},
+		"syntax error": {
+			func(L *lua.LState, conv *luacty.Converter) error {
+				return L.DoString("x = = 1")
+			},
+			"Lua syntax error",
+			"near '=': syntax error.",
+			lineRange("<string>", 1, 5),
+		},
+		"other error": {
+			func(L *lua.LState, conv *luacty.Converter) error {
+				return errors.New("something else")
+			},
+			"Lua error",
+			"something else.",
+			subject,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := luacty.NewConverter(L)
+
+			diags := Diagnostics(test.Err(L, conv), subject)
+			if test.WantSummary == "" {
+				if len(diags) != 0 {
+					t.Errorf("unexpected diagnostics: %s", diags.Error())
+				}
+				return
+			}
+			if len(diags) != 1 {
+				t.Fatalf("wrong number of diagnostics %d; want 1", len(diags))
+			}
+			diag := diags[0]
+			if diag.Severity != hcl.DiagError {
+				t.Errorf("wrong severity %#v", diag.Severity)
+			}
+			if diag.Summary != test.WantSummary {
+				t.Errorf("wrong summary\ngot:  %s\nwant: %s", diag.Summary, test.WantSummary)
+			}
+			if diag.Detail != test.WantDetail {
+				t.Errorf("wrong detail\ngot:  %s\nwant: %s", diag.Detail, test.WantDetail)
+			}
+			if (diag.Subject == nil) != (test.WantSubject == nil) || (diag.Subject != nil && *diag.Subject != *test.WantSubject) {
+				t.Errorf("wrong subject\ngot:  %#v\nwant: %#v", diag.Subject, test.WantSubject)
+			}
+		})
+	}
+}
+
+func TestErrorsDiagnostics(t *testing.T) {
+	errs := []error{
+		cty.GetAttrPath("a").NewErrorf("first"),
+		function.NewArgErrorf(0, "second"),
+	}
+	diags := ErrorsDiagnostics(errs, nil)
+	if len(diags) != 2 {
+		t.Fatalf("wrong number of diagnostics %d; want 2", len(diags))
+	}
+	if got, want := diags[0].Detail, "The value at .a is not valid: first."; got != want {
+		t.Errorf("wrong detail\ngot:  %s\nwant: %s", got, want)
+	}
+	if got, want := diags[1].Detail, "Invalid value for argument 1: second."; got != want {
+		t.Errorf("wrong detail\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestFunctionRange(t *testing.T) {
+	L := lua.NewState()
+	if err := L.DoString("\nf = function()\n  return {}\nend"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := FunctionRange(L.GetGlobal("f").(*lua.LFunction))
+	want := &hcl.Range{
+		Filename: "<string>",
+		Start:    hcl.Pos{Line: 2, Column: 1},
+		End:      hcl.Pos{Line: 4, Column: 1},
+	}
+	if got == nil || *got != *want {
+		t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, want)
+	}
+
+	if got := FunctionRange(L.NewFunction(func(L *lua.LState) int { return 0 })); got != nil {
+		t.Errorf("wrong result for Go function %#v; want nil", got)
+	}
+}
+
+func TestFormatPath(t *testing.T) {
+	tests := map[string]struct {
+		Path cty.Path
+		Want string
+	}{
+		"empty": {
+			nil,
+			"",
+		},
+		"attributes and indices": {
+			cty.GetAttrPath("servers").IndexInt(2).GetAttr("port"),
+			".servers[2].port",
+		},
+		"map key": {
+			cty.GetAttrPath("tags").IndexString("a b"),
+			`.tags["a b"]`,
+		},
+		"non-identifier attribute": {
+			cty.GetAttrPath("a b"),
+			`["a b"]`,
+		},
+		"unknown key": {
+			cty.IndexPath(cty.UnknownVal(cty.String)),
+			"[...]",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := FormatPath(test.Path); got != test.Want {
+				t.Errorf("wrong result\ngot:  %s\nwant: %s", got, test.Want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package luacty
+
+import (
+	"fmt"
+	"reflect"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// LuaValueType is a cty capsule type that carries an arbitrary Lua value,
+// allowing values that have no cty equivalent, such as functions,
+// coroutines and userdata created by other packages, to pass through cty
+// structures unchanged.
+//
+// Two values of LuaValueType are equal if they carry the same Lua value,
+// using the same rules as Lua's raw equality.
+//
+// Any Lua value can be converted to LuaValueType by passing it as the
+// target type of ToCtyValue. Converters created with WithLuaValues also
+// choose LuaValueType automatically for values that would otherwise be
+// rejected.
+var LuaValueType = cty.CapsuleWithOps("lua value", reflect.TypeOf((*lua.LValue)(nil)).Elem(), &cty.CapsuleOps{
+	GoString: func(val interface{}) string {
+		return fmt.Sprintf("luacty.LuaValueVal(%s)", *(val.(*lua.LValue)))
+	},
+	TypeGoString: func(_ reflect.Type) string {
+		return "luacty.LuaValueType"
+	},
+	Equals: func(a, b interface{}) cty.Value {
+		return cty.BoolVal(*(a.(*lua.LValue)) == *(b.(*lua.LValue)))
+	},
+	RawEquals: func(a, b interface{}) bool {
+		return *(a.(*lua.LValue)) == *(b.(*lua.LValue))
+	},
+})
+
+// LuaValueVal returns a value of LuaValueType carrying the given Lua value.
+func LuaValueVal(v lua.LValue) cty.Value {
+	return cty.CapsuleVal(LuaValueType, &v)
+}
+
+// AsLuaValue returns the Lua value carried by the given value, which must be
+// a known, non-null value of LuaValueType.
+//
+// The result belongs to the Lua state it was created in. For example, a
+// function can be used with ToCtyFunction only on a converter for that same
+// state.
+func AsLuaValue(v cty.Value) lua.LValue {
+	return *(v.EncapsulatedValue().(*lua.LValue))
+}
+
+// WithLuaValues is a ConverterOption that causes Lua values with no cty
+// equivalent to be converted to values of LuaValueType when a type is
+// chosen automatically, rather than causing an error. This allows a script
+// to return, for example, a table containing a function:
+//
+//     return {handler = function() ... end, name = "x"}
+//
+// which then converts to an object whose "handler" attribute is a value of
+// LuaValueType carrying the function.
+//
+// This affects functions, coroutines, channels and userdata that was not
+// created by this package, both in ImpliedCtyType and in ToCtyValue when
+// given cty.DynamicPseudoType as the target type.
+func WithLuaValues() ConverterOption {
+	return func(c *Converter) {
+		c.luaValues = true
+	}
+}
+
+// luaValueVal converts the given Lua value to LuaValueType, unwrapping it
+// instead if it is already a wrapped value of that type.
+func luaValueVal(val lua.LValue) cty.Value {
+	if ud, isUD := val.(*lua.LUserData); isUD {
+		if ctyV, isCty := ud.Value.(cty.Value); isCty && ctyV.Type().Equals(LuaValueType) {
+			return ctyV
+		}
+	}
+	return LuaValueVal(val)
+}
@@ -0,0 +1,97 @@
+package luacty
+
+import (
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestConverterWithLuaValues(t *testing.T) {
+	L := lua.NewState()
+	conv := NewConverter(L, WithLuaValues())
+	if err := L.DoString(`v = {handler = function(name) return "Hello, " .. name end, name = "x"}`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	table := L.GetGlobal("v").(*lua.LTable)
+
+	got, err := conv.ToCtyValue(table, cty.DynamicPseudoType)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	wantTy := cty.Object(map[string]cty.Type{
+		"handler": LuaValueType,
+		"name":    cty.String,
+	})
+	if !got.Type().Equals(wantTy) {
+		t.Fatalf("wrong type\ngot:  %#v\nwant: %#v", got.Type(), wantTy)
+	}
+
+	handler := got.GetAttr("handler")
+	if got, want := AsLuaValue(handler), table.RawGetString("handler"); got != want {
+		t.Errorf("wrong handler %s; want %s", got, want)
+	}
+	if !handler.Equals(LuaValueVal(table.RawGetString("handler"))).True() {
+		t.Errorf("handler is not equal to a new value carrying the same function")
+	}
+
+	f := conv.ToCtyFunction(AsLuaValue(handler).(*lua.LFunction))
+	result, err := f.Call([]cty.Value{cty.StringVal("world")})
+	if err != nil {
+		t.Fatalf("unexpected error calling handler: %s", err)
+	}
+	if want := cty.StringVal("Hello, world"); !result.RawEquals(want) {
+		t.Errorf("wrong result\ngot:  %#v\nwant: %#v", result, want)
+	}
+
+	// The value passes back into Lua as the original function.
+	L.SetGlobal("h", conv.WrapCtyValueNative(handler))
+	if err := L.DoString(`assert(h == v.handler)`); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	back, err := conv.ToLuaValue(got, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := back.(*lua.LTable).RawGetString("handler"), table.RawGetString("handler"); got != want {
+		t.Errorf("wrong handler from ToLuaValue %s; want %s", got, want)
+	}
+}
+
+func TestConverterLuaValueType(t *testing.T) {
+	L := lua.NewState()
+	conv := NewConverter(L)
+	fn := L.NewFunction(func(L *lua.LState) int { return 0 })
+	ud := L.NewUserData()
+
+	// Without WithLuaValues, opaque values are still rejected when the type
+	// is chosen automatically...
+	if _, err := conv.ImpliedCtyType(fn); err == nil {
+		t.Errorf("ImpliedCtyType succeeded for function; want error")
+	}
+	if _, err := conv.ImpliedCtyType(ud); err == nil {
+		t.Errorf("ImpliedCtyType succeeded for userdata; want error")
+	}
+
+	// ...but any value can be converted to LuaValueType explicitly.
+	for _, v := range []lua.LValue{fn, ud, lua.LString("hello"), L.NewTable()} {
+		got, err := conv.ToCtyValue(v, LuaValueType)
+		if err != nil {
+			t.Errorf("unexpected error converting %s: %s", v, err)
+			continue
+		}
+		if AsLuaValue(got) != v {
+			t.Errorf("wrong result %s; want %s", AsLuaValue(got), v)
+		}
+	}
+
+	// A wrapped value of LuaValueType is unwrapped rather than wrapped again.
+	want := LuaValueVal(fn)
+	got, err := conv.ToCtyValue(conv.WrapCtyValue(want), LuaValueType)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !got.RawEquals(want) {
+		t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, want)
+	}
+}
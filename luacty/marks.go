@@ -0,0 +1,134 @@
+package luacty
+
+import (
+	"fmt"
+	"sort"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// MarkPolicy controls how Lua scripts can inspect and change the marks on
+// cty values, which can be passed to WithMarkPolicy.
+//
+// Operations on wrapped values always preserve marks, regardless of this
+// policy: the result of concatenating a marked string, for example, has the
+// same marks as the string. The policy only affects the methods that deal
+// with marks directly.
+type MarkPolicy struct {
+	// Names maps the names that scripts use for marks to the mark values
+	// they represent. If Names is nil, marks that are Go strings are named
+	// by themselves and other marks have no name.
+	//
+	// Marks without a name are invisible to scripts: they are not returned
+	// by the marks method, and scripts cannot add or remove them.
+	Names map[string]interface{}
+
+	// AllowUnmark permits scripts to remove marks using the unmark method.
+	// Scripts can always add marks, because doing so can only restrict how
+	// a value may be used.
+	AllowUnmark bool
+}
+
+// WithMarkPolicy is a ConverterOption that selects how Lua scripts can
+// inspect and change the marks on cty values, as described for MarkPolicy.
+// The default policy names string marks by themselves and does not allow
+// scripts to remove marks.
+func WithMarkPolicy(policy MarkPolicy) ConverterOption {
+	return func(c *Converter) {
+		c.markPolicy = policy
+	}
+}
+
+// markNamed returns the mark value represented by the given name.
+func (c *Converter) markNamed(name string) (interface{}, bool) {
+	if c.markPolicy.Names == nil {
+		return name, true
+	}
+	mark, ok := c.markPolicy.Names[name]
+	return mark, ok
+}
+
+// markName returns the name of the given mark value.
+func (c *Converter) markName(mark interface{}) (string, bool) {
+	if c.markPolicy.Names == nil {
+		name, ok := mark.(string)
+		return name, ok
+	}
+	for name, named := range c.markPolicy.Names {
+		if named == mark {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// checkMark is a helper for Lua function implementations that expect a mark
+// name at the given stack index. It raises an OperationError of kind
+// "argument" if the value at that index is not the name of a mark.
+func (c *Converter) checkMark(L *lua.LState, n int) interface{} {
+	name := c.checkString(L, n)
+	mark, ok := c.markNamed(name)
+	if !ok {
+		c.raiseArgError(L, "argument", n, fmt.Errorf("unknown mark %q", name))
+	}
+	return mark
+}
+
+func (c *Converter) ctyValueMarks(L *lua.LState) int {
+	v := c.checkValue(L, 1)
+
+	var names []string
+	for mark := range v.Marks() {
+		if name, ok := c.markName(mark); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	table := L.NewTable()
+	for _, name := range names {
+		table.Append(lua.LString(name))
+	}
+	table.Metatable = c.arrayMetatable
+	L.Push(table)
+	return 1
+}
+
+func (c *Converter) ctyValueMark(L *lua.LState) int {
+	v := c.checkValue(L, 1)
+	mark := c.checkMark(L, 2)
+	L.Push(c.wrapResult(v.Mark(mark)))
+	return 1
+}
+
+func (c *Converter) ctyValueUnmark(L *lua.LState) int {
+	v := c.checkValue(L, 1)
+	if !c.markPolicy.AllowUnmark {
+		c.raiseErrorf(L, "operation", "removing marks is not permitted")
+		return 0
+	}
+
+	// We remove only the marks that scripts can see, wherever they are
+	// in the value, and retain any others.
+	v, pvms := v.UnmarkDeepWithPaths()
+	for i, pvm := range pvms {
+		remain := make(cty.ValueMarks)
+		for mark := range pvm.Marks {
+			if _, named := c.markName(mark); !named {
+				remain[mark] = struct{}{}
+			}
+		}
+		pvms[i].Marks = remain
+	}
+	L.Push(c.wrapResult(v.MarkWithPaths(pvms)))
+	return 1
+}
+
+// moduleHasMark implements the "has_mark" function in the Lua module.
+func (c *Converter) moduleHasMark(L *lua.LState) int {
+	v := c.checkValue(L, 1)
+	mark := c.checkMark(L, 2)
+	L.Push(lua.LBool(v.HasMark(mark)))
+	return 1
+}
@@ -0,0 +1,226 @@
+package luacty
+
+import (
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestConverterMarkedOperations(t *testing.T) {
+	tests := map[string]struct {
+		Src  string
+		Want cty.Value
+	}{
+		"concat": {
+			`return secret .. "!"`,
+			cty.StringVal("hunter2!").Mark("sensitive"),
+		},
+		"concat unknown": {
+			`return secret .. cty.unknown(cty.String)`,
+			cty.UnknownVal(cty.String).Mark("sensitive"),
+		},
+		"arithmetic": {
+			`return num + 1`,
+			cty.NumberIntVal(3).Mark("sensitive"),
+		},
+		"length": {
+			`return #secret`,
+			cty.NumberIntVal(7).Mark("sensitive"),
+		},
+		"equals": {
+			`return secret == cty.string("hunter2")`,
+			cty.True,
+		},
+		"less than": {
+			`return num < cty.number(3)`,
+			cty.True,
+		},
+		"index marked list": {
+			`return list[1]`,
+			cty.StringVal("b").Mark("sensitive"),
+		},
+		"attribute of marked object": {
+			`return obj.password`,
+			cty.StringVal("hunter2").Mark("sensitive"),
+		},
+		"index method": {
+			`return list:index(0)`,
+			cty.StringVal("a").Mark("sensitive"),
+		},
+		"has_index method": {
+			`return list:has_index(1)`,
+			cty.True.Mark("sensitive"),
+		},
+		"pairs": {
+			`
+				local result = ""
+				for _, v in cty.pairs(list) do
+					result = result .. v
+				end
+				return result
+			`,
+			cty.StringVal("ab").Mark("sensitive"),
+		},
+		"index marked list with unknown key": {
+			`return list[cty.unknown(cty.Number)]`,
+			cty.DynamicVal.Mark("sensitive"),
+		},
+		"index marked map with unknown key": {
+			`return cty.methods(map):index(cty.unknown(cty.String))`,
+			cty.UnknownVal(cty.String).Mark("sensitive"),
+		},
+		"index marked map with unknown key via operator": {
+			`return map[cty.unknown(cty.String)]`,
+			cty.DynamicVal.Mark("sensitive"),
+		},
+		"index marked object with unknown key": {
+			`return obj[cty.unknown(cty.String)]`,
+			cty.DynamicVal.Mark("sensitive"),
+		},
+		"index method marked object with unknown key": {
+			`return cty.methods(obj):index(cty.unknown(cty.String))`,
+			cty.DynamicVal.Mark("sensitive"),
+		},
+		"index with marked unknown key": {
+			`return cty.list{"a"}[cty.unknown(cty.Number):mark("sensitive")]`,
+			cty.DynamicVal.Mark("sensitive"),
+		},
+		"attribute of marked unknown value": {
+			`return dyn.foo`,
+			cty.DynamicVal.Mark("sensitive"),
+		},
+		"get_attr of marked unknown value": {
+			`return cty.methods(dyn):get_attr("foo")`,
+			cty.DynamicVal.Mark("sensitive"),
+		},
+		"index method of marked unknown value": {
+			`return cty.methods(dyn):index("foo")`,
+			cty.DynamicVal.Mark("sensitive"),
+		},
+		"has_index of marked unknown value": {
+			`return cty.methods(dyn):has_index("foo")`,
+			cty.UnknownVal(cty.Bool).Mark("sensitive"),
+		},
+		"has_mark": {
+			`return cty.has_mark(secret, "sensitive") and not cty.has_mark(cty.string("x"), "sensitive")`,
+			cty.True,
+		},
+		"marks": {
+			`return table.concat(secret:marks(), ",")`,
+			cty.StringVal("sensitive"),
+		},
+		"mark": {
+			`return cty.string("x"):mark("sensitive")`,
+			cty.StringVal("x").Mark("sensitive"),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L)
+			conv.PreloadModule("cty")
+			if err := L.DoString(`cty = require("cty")`); err != nil {
+				t.Fatalf("failed to load module: %s", err)
+			}
+			L.SetGlobal("secret", conv.WrapCtyValue(cty.StringVal("hunter2").Mark("sensitive")))
+			L.SetGlobal("num", conv.WrapCtyValue(cty.NumberIntVal(2).Mark("sensitive")))
+			L.SetGlobal("list", conv.WrapCtyValue(cty.ListVal([]cty.Value{
+				cty.StringVal("a"),
+				cty.StringVal("b"),
+			}).Mark("sensitive")))
+			L.SetGlobal("map", conv.WrapCtyValue(cty.MapVal(map[string]cty.Value{
+				"a": cty.StringVal("b"),
+			}).Mark("sensitive")))
+			L.SetGlobal("dyn", conv.WrapCtyValue(cty.DynamicVal.Mark("sensitive")))
+			L.SetGlobal("obj", conv.WrapCtyValue(cty.ObjectVal(map[string]cty.Value{
+				"password": cty.StringVal("hunter2"),
+			}).Mark("sensitive")))
+
+			if err := L.DoString(test.Src); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			got, err := conv.ToCtyValue(L.Get(-1), test.Want.Type())
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !got.RawEquals(test.Want) {
+				t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, test.Want)
+			}
+		})
+	}
+}
+
+func TestConverterMarkPolicy(t *testing.T) {
+	type otherMark struct{}
+	val := cty.StringVal("hunter2").Mark("sensitive").Mark(otherMark{})
+
+	t.Run("unmark not allowed", func(t *testing.T) {
+		L := lua.NewState()
+		conv := NewConverter(L)
+		L.SetGlobal("v", conv.WrapCtyValue(val))
+
+		err := L.DoString(`return v:unmark()`)
+		opErr, ok := AsOperationError(err)
+		if !ok {
+			t.Fatalf("wrong error %v; want an operation error", err)
+		}
+		if got, want := opErr.Err.Error(), "removing marks is not permitted"; got != want {
+			t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+		}
+	})
+
+	t.Run("unmark allowed", func(t *testing.T) {
+		L := lua.NewState()
+		conv := NewConverter(L, WithMarkPolicy(MarkPolicy{AllowUnmark: true}))
+		L.SetGlobal("v", conv.WrapCtyValue(val))
+
+		if err := L.DoString(`return v:unmark()`); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		got, err := conv.ToCtyValue(L.Get(-1), cty.String)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		// Only the marks that scripts can see are removed.
+		want := cty.StringVal("hunter2").Mark(otherMark{})
+		if !got.RawEquals(want) {
+			t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, want)
+		}
+	})
+
+	t.Run("named marks", func(t *testing.T) {
+		L := lua.NewState()
+		conv := NewConverter(L, WithMarkPolicy(MarkPolicy{
+			Names: map[string]interface{}{
+				"other": otherMark{},
+			},
+		}))
+		conv.PreloadModule("cty")
+		L.SetGlobal("v", conv.WrapCtyValue(val))
+
+		err := L.DoString(`
+			local cty = require("cty")
+			local marks = v:marks()
+			assert(#marks == 1 and marks[1] == "other", "wrong marks")
+			assert(cty.has_mark(v, "other"), "missing mark")
+			assert(not pcall(cty.has_mark, v, "sensitive"), "sensitive is not a named mark")
+		`)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		err = L.DoString(`return v:mark("nope")`)
+		opErr, ok := AsOperationError(err)
+		if !ok {
+			t.Fatalf("wrong error %v; want an operation error", err)
+		}
+		if opErr.Kind != "argument" || opErr.Arg != 2 {
+			t.Errorf("wrong kind %q and argument %d; want \"argument\" and 2", opErr.Kind, opErr.Arg)
+		}
+		if got, want := opErr.Err.Error(), `unknown mark "nope"`; got != want {
+			t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+		}
+	})
+}
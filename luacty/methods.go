@@ -0,0 +1,292 @@
+package luacty
+
+import (
+	"errors"
+	"fmt"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+)
+
+// ctyMethods returns the table of methods that wrapped values expose
+// to Lua via the colon syntax, such as v:is_known().
+//
+// Methods are resolved by ctyIndex only for values whose type does not
+// accept string keys, so that they cannot collide with map keys or object
+// attribute names. The methods of all values are also available via the
+// proxy objects returned by the "methods" function in the Lua module.
+func (c *Converter) ctyMethods() *lua.LTable {
+	L := c.lstate
+	table := L.NewTable()
+
+	L.SetFuncs(table, map[string]lua.LGFunction{
+		"is_known":        c.ctyValuePredicate(cty.Value.IsKnown),
+		"is_null":         c.ctyValuePredicate(cty.Value.IsNull),
+		"is_wholly_known": c.ctyValuePredicate(cty.Value.IsWhollyKnown),
+		"type":            c.ctyValueType,
+		"convert":         c.ctyValueConvert,
+		"get_attr":        c.ctyValueGetAttr,
+		"index":           c.ctyValueIndex,
+		"has_index":       c.ctyValueHasIndex,
+		"length":          c.ctyLength,
+		"raw_equals":      c.ctyValueRawEquals,
+		"tonative":        c.ctyValueToNative,
+		"marks":           c.ctyValueMarks,
+		"mark":            c.ctyValueMark,
+		"unmark":          c.ctyValueUnmark,
+	})
+
+	return table
+}
+
+// ctyMethodsMetatable returns the metatable used for the method proxy
+// objects returned by the "methods" function in the Lua module.
+//
+// A method proxy is a userdata value containing the same cty value as the
+// value it was created from, so it can be used anywhere that value could,
+// but its only behavior in Lua is to expose the value's methods.
+func (c *Converter) ctyMethodsMetatable() *lua.LTable {
+	L := c.lstate
+	table := L.NewTable()
+
+	table.RawSet(lua.LString("__index"), c.methods)
+	table.RawSet(lua.LString("__newindex"), L.NewFunction(c.ctyInvalidOp("methods are immutable")))
+
+	return table
+}
+
+// wrapMethods returns a method proxy for the given value.
+func (c *Converter) wrapMethods(val cty.Value) lua.LValue {
+	ret := c.lstate.NewUserData()
+	ret.Value = val
+	ret.Metatable = c.methodsMetatable
+	return ret
+}
+
+// checkValue is a helper for Lua function implementations that expect
+// a cty value at the given stack index. Native Lua values are converted
+// using the usual rules for ToCtyValue. It raises an OperationError of kind
+// "argument" if the value at that index cannot be converted.
+func (c *Converter) checkValue(L *lua.LState, n int) cty.Value {
+	v, err := c.ToCtyValue(L.CheckAny(n), cty.DynamicPseudoType)
+	if err != nil {
+		c.raiseArgError(L, "argument", n, err)
+	}
+	return v
+}
+
+// checkString is like LState.CheckString except that it raises an
+// OperationError of kind "argument" if the value at the given stack index
+// is not a string.
+func (c *Converter) checkString(L *lua.LState, n int) string {
+	v := L.CheckAny(n)
+	if !lua.LVCanConvToString(v) {
+		c.raiseArgError(L, "argument", n, errors.New("a string is required"))
+	}
+	return lua.LVAsString(v)
+}
+
+func (c *Converter) ctyValuePredicate(pred func(cty.Value) bool) lua.LGFunction {
+	return func(L *lua.LState) int {
+		v := c.checkValue(L, 1)
+		L.Push(lua.LBool(pred(v)))
+		return 1
+	}
+}
+
+func (c *Converter) ctyValueType(L *lua.LState) int {
+	v := c.checkValue(L, 1)
+	L.Push(c.WrapCtyType(v.Type()))
+	return 1
+}
+
+func (c *Converter) ctyValueConvert(L *lua.LState) int {
+	v := c.checkValue(L, 1)
+	ty := c.checkType(L, 2)
+
+	result, err := convert.Convert(v, ty)
+	if err != nil {
+		c.raiseError(L, "conversion", err)
+		return 0
+	}
+
+	L.Push(c.wrapResult(result))
+	return 1
+}
+
+func (c *Converter) ctyValueGetAttr(L *lua.LState) int {
+	v, marks := c.checkValue(L, 1).Unmark()
+	name := c.checkString(L, 2)
+
+	ty := v.Type()
+	switch {
+	case ty == cty.DynamicPseudoType:
+		L.Push(c.wrapResult(cty.DynamicVal.WithMarks(marks)))
+		return 1
+	case !ty.IsObjectType():
+		c.raiseErrorf(L, "index", "%s has no attributes", ty.FriendlyName())
+		return 0
+	case !ty.HasAttribute(name):
+		c.raiseErrorf(L, "index", "object has no attribute %q", name)
+		return 0
+	case v.IsNull():
+		c.raiseErrorf(L, "index", "can't get an attribute of a null value")
+		return 0
+	}
+
+	L.Push(c.wrapResult(v.GetAttr(name).WithMarks(marks)))
+	return 1
+}
+
+func (c *Converter) ctyValueIndex(L *lua.LState) int {
+	coll := c.checkValue(L, 1)
+	key, err := c.indexKey(coll, L.CheckAny(2))
+	if err != nil {
+		c.raiseArgError(L, "argument", 2, err)
+		return 0
+	}
+
+	// Marks on the collection and the key apply to every result, including
+	// the unknown results we return when we can't look up the element.
+	coll, collMarks := coll.Unmark()
+	key, keyMarks := key.Unmark()
+
+	if coll.Type() == cty.DynamicPseudoType {
+		L.Push(c.wrapResult(cty.DynamicVal.WithMarks(collMarks, keyMarks)))
+		return 1
+	}
+	if coll.IsNull() {
+		c.raiseErrorf(L, "index", "can't index a null value")
+		return 0
+	}
+
+	if coll.Type().IsObjectType() {
+		if !key.IsKnown() {
+			L.Push(c.wrapResult(cty.DynamicVal.WithMarks(collMarks, keyMarks)))
+			return 1
+		}
+		attrName := key.AsString()
+		if !coll.Type().HasAttribute(attrName) {
+			c.raiseErrorf(L, "index", "object has no attribute %q", attrName)
+			return 0
+		}
+		L.Push(c.wrapResult(coll.GetAttr(attrName).WithMarks(collMarks, keyMarks)))
+		return 1
+	}
+
+	if coll.Type().IsSetType() {
+		// Sets are "indexed" by their elements, so indexing just confirms
+		// that the given element is present.
+		hasElem := coll.HasElement(key)
+		if hasElem.IsKnown() && hasElem.False() {
+			c.raiseErrorf(L, "index", "set has no such element")
+			return 0
+		}
+		if !hasElem.IsKnown() {
+			L.Push(c.wrapResult(cty.UnknownVal(coll.Type().ElementType()).WithMarks(collMarks, keyMarks)))
+			return 1
+		}
+		L.Push(c.wrapResult(key.WithMarks(collMarks, keyMarks)))
+		return 1
+	}
+
+	hasIndex := coll.HasIndex(key)
+	if hasIndex.IsKnown() && hasIndex.False() {
+		c.raiseErrorf(L, "index", "%s has no element for the given key", coll.Type().FriendlyName())
+		return 0
+	}
+
+	L.Push(c.wrapResult(coll.Index(key).WithMarks(collMarks, keyMarks)))
+	return 1
+}
+
+func (c *Converter) ctyValueHasIndex(L *lua.LState) int {
+	coll := c.checkValue(L, 1)
+	key, err := c.indexKey(coll, L.CheckAny(2))
+	if err != nil {
+		c.raiseArgError(L, "argument", 2, err)
+		return 0
+	}
+
+	coll, collMarks := coll.Unmark()
+	key, keyMarks := key.Unmark()
+
+	var result cty.Value
+	switch {
+	case coll.Type() == cty.DynamicPseudoType:
+		result = cty.UnknownVal(cty.Bool)
+	case coll.IsNull():
+		result = cty.False
+	case coll.Type().IsObjectType():
+		if !key.IsKnown() {
+			result = cty.UnknownVal(cty.Bool)
+		} else {
+			result = cty.BoolVal(coll.Type().HasAttribute(key.AsString()))
+		}
+	case coll.Type().IsSetType():
+		result = coll.HasElement(key)
+	default:
+		result = coll.HasIndex(key)
+	}
+	result = result.WithMarks(collMarks, keyMarks)
+
+	// The result is a native Lua boolean whenever possible, so that it can
+	// be used directly in conditionals, but we must return a wrapped value
+	// if the result is unknown or marked.
+	if !result.IsKnown() || result.IsMarked() {
+		L.Push(c.wrapResult(result))
+		return 1
+	}
+	L.Push(lua.LBool(result.True()))
+	return 1
+}
+
+func (c *Converter) ctyValueRawEquals(L *lua.LState) int {
+	a := c.checkValue(L, 1)
+	b := c.checkValue(L, 2)
+	L.Push(lua.LBool(a.RawEquals(b)))
+	return 1
+}
+
+func (c *Converter) ctyValueToNative(L *lua.LState) int {
+	v := c.checkValue(L, 1)
+
+	result, err := c.ToLuaValue(v, nil)
+	if err != nil {
+		c.raiseError(L, "conversion", err)
+		return 0
+	}
+
+	L.Push(result)
+	return 1
+}
+
+// indexKey converts the given Lua value to a key suitable for indexing the
+// given collection, or returns an error if the collection cannot be indexed
+// or the key is not suitable.
+func (c *Converter) indexKey(coll cty.Value, keyL lua.LValue) (cty.Value, error) {
+	collTy := coll.Type()
+	var keyType cty.Type
+	switch {
+	case collTy.IsMapType() || collTy.IsObjectType():
+		keyType = cty.String
+	case collTy.IsListType() || collTy.IsTupleType():
+		keyType = cty.Number
+	case collTy.IsSetType():
+		keyType = collTy.ElementType()
+	case collTy == cty.DynamicPseudoType:
+		keyType = cty.DynamicPseudoType
+	default:
+		return cty.DynamicVal, fmt.Errorf("can't index value of type %s", collTy.FriendlyName())
+	}
+
+	key, err := c.ToCtyValue(keyL, keyType)
+	if err != nil {
+		return cty.DynamicVal, fmt.Errorf("invalid key for %s: %s", collTy.FriendlyName(), err)
+	}
+	if key.IsNull() {
+		return cty.DynamicVal, fmt.Errorf("invalid key for %s: must not be null", collTy.FriendlyName())
+	}
+	return key, nil
+}
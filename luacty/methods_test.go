@@ -0,0 +1,280 @@
+package luacty
+
+import (
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestConverterValueMethods(t *testing.T) {
+	tests := map[string]struct {
+		Vals   map[string]cty.Value
+		Assert string
+	}{
+		"is_known": {
+			map[string]cty.Value{
+				"a": cty.StringVal("hello"),
+				"b": cty.UnknownVal(cty.String),
+				"c": cty.DynamicVal,
+			},
+			`
+				assert(a:is_known())
+				assert(not b:is_known())
+				assert(not c:is_known())
+			`,
+		},
+		"is_null": {
+			map[string]cty.Value{
+				"a": cty.StringVal("hello"),
+				"b": cty.NullVal(cty.String),
+				"c": cty.NullVal(cty.List(cty.String)),
+			},
+			`
+				assert(not a:is_null())
+				assert(b:is_null())
+				assert(c:is_null())
+			`,
+		},
+		"is_wholly_known": {
+			map[string]cty.Value{
+				"a": cty.ListVal([]cty.Value{cty.StringVal("hello")}),
+				"b": cty.ListVal([]cty.Value{cty.UnknownVal(cty.String)}),
+			},
+			`
+				assert(a:is_known() and a:is_wholly_known())
+				assert(b:is_known() and not b:is_wholly_known())
+			`,
+		},
+		"type": {
+			map[string]cty.Value{
+				"a": cty.StringVal("hello"),
+				"b": cty.UnknownVal(cty.List(cty.Number)),
+			},
+			`
+				assert(a:type() == cty.String)
+				assert(b:type() == cty.List(cty.Number))
+			`,
+		},
+		"unknown constructor": {
+			map[string]cty.Value{
+				"want": cty.UnknownVal(cty.Number),
+			},
+			`
+				local v = cty.unknown(cty.Number)
+				assert(not v:is_known())
+				assert(v:type() == cty.Number)
+			`,
+		},
+		"null constructor": {
+			map[string]cty.Value{},
+			`
+				local v = cty.null("list(string)")
+				assert(v:is_known())
+				assert(v:is_null())
+				assert(v:type() == cty.List(cty.String))
+			`,
+		},
+		"unknown arithmetic": {
+			map[string]cty.Value{
+				"a": cty.UnknownVal(cty.Number),
+			},
+			`
+				local v = a + 1
+				assert(not v:is_known())
+			`,
+		},
+		"index into unknown dynamic value": {
+			map[string]cty.Value{
+				"a": cty.DynamicVal,
+			},
+			`
+				local v = a.foo
+				assert(not v:is_known())
+			`,
+		},
+		"convert": {
+			map[string]cty.Value{
+				"a":    cty.StringVal("12"),
+				"want": cty.NumberIntVal(12),
+			},
+			`
+				assert(a:convert(cty.Number) == want)
+				assert(a:convert("number") == want)
+			`,
+		},
+		"get_attr": {
+			map[string]cty.Value{
+				"a": cty.ObjectVal(map[string]cty.Value{
+					"type": cty.StringVal("web"),
+				}),
+				"want": cty.StringVal("web"),
+			},
+			`
+				-- the attribute takes priority over the method of the same name
+				assert(a.type == want)
+				assert(cty.methods(a):get_attr("type") == want)
+				assert(cty.methods(a):type():is_object())
+			`,
+		},
+		"index": {
+			map[string]cty.Value{
+				"l":    cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}),
+				"m":    cty.MapVal(map[string]cty.Value{"k": cty.StringVal("b")}),
+				"s":    cty.SetVal([]cty.Value{cty.StringVal("b")}),
+				"want": cty.StringVal("b"),
+			},
+			`
+				assert(l:index(1) == want)
+				assert(cty.methods(m):index("k") == want)
+				assert(s:index("b") == want)
+			`,
+		},
+		"has_index": {
+			map[string]cty.Value{
+				"l": cty.ListVal([]cty.Value{cty.StringVal("a")}),
+				"m": cty.MapVal(map[string]cty.Value{"k": cty.StringVal("b")}),
+				"o": cty.ObjectVal(map[string]cty.Value{"k": cty.StringVal("b")}),
+				"s": cty.SetVal([]cty.Value{cty.StringVal("b")}),
+				"u": cty.UnknownVal(cty.List(cty.String)),
+			},
+			`
+				assert(l:has_index(0))
+				assert(not l:has_index(1))
+				assert(cty.methods(m):has_index("k"))
+				assert(not cty.methods(m):has_index("z"))
+				assert(cty.methods(o):has_index("k"))
+				assert(s:has_index("b"))
+				assert(not s:has_index("c"))
+				assert(not u:has_index(0):is_known())
+			`,
+		},
+		"length": {
+			map[string]cty.Value{
+				"l":    cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}),
+				"want": cty.NumberIntVal(2),
+			},
+			`
+				assert(l:length() == want)
+				assert(cty.methods(cty.map{a = 1, b = 2}):length() == want)
+			`,
+		},
+		"raw_equals": {
+			map[string]cty.Value{
+				"a": cty.UnknownVal(cty.String),
+				"b": cty.UnknownVal(cty.String),
+				"c": cty.StringVal("c"),
+			},
+			`
+				assert(a:raw_equals(b))
+				assert(not a:raw_equals(c))
+			`,
+		},
+		"tonative": {
+			map[string]cty.Value{
+				"s": cty.StringVal("hello"),
+				"n": cty.NumberIntVal(12),
+				"b": cty.False,
+				"z": cty.NullVal(cty.String),
+			},
+			`
+				assert(s:tonative() == "hello")
+				assert(n:tonative() == 12)
+				assert(b:tonative() == false)
+				assert(z:tonative() == nil)
+				assert(cty.list{"a", "b"}:tonative()[2] == "b")
+			`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L)
+			conv.PreloadModule("cty")
+			if err := L.DoString(`cty = require("cty")`); err != nil {
+				t.Fatalf("failed to load module: %s", err)
+			}
+			addTestFuncs(L, t)
+
+			for n, v := range test.Vals {
+				L.SetGlobal(n, conv.WrapCtyValue(v))
+			}
+
+			err := L.DoString(test.Assert)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestConverterValueMethodsErrors(t *testing.T) {
+	tests := map[string]struct {
+		Vals map[string]cty.Value
+		Src  string
+	}{
+		"get_attr on string": {
+			map[string]cty.Value{
+				"a": cty.StringVal("hello"),
+			},
+			`a:get_attr("foo")`,
+		},
+		"get_attr of absent attribute": {
+			map[string]cty.Value{
+				"a": cty.EmptyObjectVal,
+			},
+			`cty.methods(a):get_attr("foo")`,
+		},
+		"index out of range": {
+			map[string]cty.Value{
+				"a": cty.ListVal([]cty.Value{cty.StringVal("a")}),
+			},
+			`a:index(1)`,
+		},
+		"index with null key": {
+			map[string]cty.Value{
+				"a": cty.ListVal([]cty.Value{cty.StringVal("a")}),
+			},
+			`a:index(nil)`,
+		},
+		"convert to incompatible type": {
+			map[string]cty.Value{
+				"a": cty.StringVal("hello"),
+			},
+			`a:convert(cty.Number)`,
+		},
+		"tonative of unknown": {
+			map[string]cty.Value{
+				"a": cty.UnknownVal(cty.String),
+			},
+			`a:tonative()`,
+		},
+		"method on primitive that does not exist": {
+			map[string]cty.Value{
+				"a": cty.StringVal("hello"),
+			},
+			`a:nonexistent()`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L)
+			conv.PreloadModule("cty")
+			if err := L.DoString(`cty = require("cty")`); err != nil {
+				t.Fatalf("failed to load module: %s", err)
+			}
+
+			for n, v := range test.Vals {
+				L.SetGlobal(n, conv.WrapCtyValue(v))
+			}
+
+			err := L.DoString(test.Src)
+			if err == nil {
+				t.Errorf("call succeeded; want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package luacty
+
+import (
+	"fmt"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// PreloadModule registers a Lua module with the given name that exposes
+// cty functionality to Lua scripts, so that scripts can access it using
+// the standard "require" function:
+//
+//     local cty = require("cty")
+//
+// The module provides the following value constructors, each of which
+// returns a cty value wrapped in the same way as WrapCtyValue:
+//
+//     cty.list{...}    cty.List with an element type chosen automatically
+//     cty.set{...}     cty.Set with an element type chosen automatically
+//     cty.map{...}     cty.Map with an element type chosen automatically
+//     cty.tuple{...}   cty.Tuple with element types chosen automatically
+//     cty.object{...}  cty.Object with attribute types chosen automatically
+//     cty.string(s)    cty.String
+//     cty.number(n)    cty.Number
+//     cty.bool(b)      cty.Bool
+//     cty.unknown(t)   an unknown value of the given type
+//     cty.null(t)      a null value of the given type
+//
+// Because an empty Lua table could represent either an empty sequence or an
+// empty object, scripts can tag a table to say which is intended. Tagging
+// returns the same table, which can still be used as a normal Lua table:
+//
+//     cty.array{...}   a table to be interpreted as a sequence
+//     cty.dict{...}    a table to be interpreted as an object
+//
+// Tags are respected wherever the type of a table is chosen automatically,
+// such as when ToCtyValue is given cty.DynamicPseudoType. Tables produced
+// by ToLuaValue are tagged in the same way.
+//
+// The constructors use the same conversion rules as ToCtyValue, and so they
+// also accept already-wrapped cty values that can convert to the requested
+// type. In particular, cty.number accepts a string, which allows scripts to
+// write numbers that are too large to represent exactly as a Lua number:
+//
+//     local id = cty.number("12345678901234567890")
+//
+// The module also exposes cty types, wrapped in the same way as WrapCtyType:
+//
+//     cty.String, cty.Number, cty.Bool, cty.DynamicPseudoType
+//     cty.List(t), cty.Map(t), cty.Set(t)
+//     cty.Object{name = t, ...}
+//     cty.Tuple{t, ...}
+//     cty.parse_type(s)   parses a type expression as with ParseTypeExpr
+//
+// Lua functions can be declared as typed cty functions using cty.func{...},
+// as described for ToCtyFunction.
+//
+// The elements of wrapped collection and structural values can be iterated
+// over using cty.pairs(v) and cty.ipairs(v), in place of the standard Lua
+// functions of the same name:
+//
+//     for k, v in cty.pairs(m) do ... end
+//
+// Finally, cty.methods(v) returns an object exposing the methods of the
+// given wrapped value. Methods are usually accessed directly using the colon
+// syntax, as in v:type(), but that is not possible for maps and objects
+// because their keys take priority, and so cty.methods(v):type() can be
+// used instead.
+//
+// cty.has_mark(v, name) returns true if the given wrapped value has the
+// mark with the given name, as described for MarkPolicy. Values also have
+// methods v:marks(), v:mark(name) and v:unmark() for inspecting, adding
+// and removing marks, subject to the converter's MarkPolicy.
+//
+// Anywhere the module expects a type, a string containing a type expression
+// is accepted in place of a type value.
+//
+// The package library must be loaded into the Lua state before calling
+// this method, or it will raise a Lua error.
+func (c *Converter) PreloadModule(name string) {
+	c.lstate.PreloadModule(name, c.moduleLoader)
+}
+
+func (c *Converter) moduleLoader(L *lua.LState) int {
+	mod := L.NewTable()
+	mod.RawSetString("String", c.WrapCtyType(cty.String))
+	mod.RawSetString("Number", c.WrapCtyType(cty.Number))
+	mod.RawSetString("Bool", c.WrapCtyType(cty.Bool))
+	mod.RawSetString("DynamicPseudoType", c.WrapCtyType(cty.DynamicPseudoType))
+	L.SetFuncs(mod, map[string]lua.LGFunction{
+		"List":   c.moduleCollectionType(cty.List),
+		"Map":    c.moduleCollectionType(cty.Map),
+		"Set":    c.moduleCollectionType(cty.Set),
+		"Object": c.moduleObjectType,
+		"Tuple":  c.moduleTupleType,
+
+		"parse_type": c.moduleParseType,
+		"methods":    c.moduleMethods,
+		"pairs":      c.ctyPairs,
+		"ipairs":     c.ctyIPairs,
+		"func":       c.moduleFunc,
+		"has_mark":   c.moduleHasMark,
+
+		"list":   c.moduleConstructor(cty.List(cty.DynamicPseudoType)),
+		"set":    c.moduleConstructor(cty.Set(cty.DynamicPseudoType)),
+		"map":    c.moduleConstructor(cty.Map(cty.DynamicPseudoType)),
+		"tuple":  c.moduleTuple,
+		"object": c.moduleObject,
+		"string": c.moduleConstructor(cty.String),
+		"number": c.moduleConstructor(cty.Number),
+		"bool":   c.moduleConstructor(cty.Bool),
+
+		"unknown": c.moduleTypedValue(cty.UnknownVal),
+		"null":    c.moduleTypedValue(cty.NullVal),
+
+		"array": c.moduleTagTable(c.arrayMetatable),
+		"dict":  c.moduleTagTable(c.dictMetatable),
+	})
+	L.Push(mod)
+	return 1
+}
+
+// moduleConstructor returns a Lua function that converts its single argument
+// to the given type and returns the wrapped result.
+func (c *Converter) moduleConstructor(ty cty.Type) lua.LGFunction {
+	return func(L *lua.LState) int {
+		vL := L.CheckAny(1)
+		v, err := c.ToCtyValue(vL, ty)
+		if err != nil {
+			L.ArgError(1, err.Error())
+			return 0
+		}
+		L.Push(c.WrapCtyValue(v))
+		return 1
+	}
+}
+
+// moduleTagTable returns a Lua function that sets the given metatable on
+// the table given as its argument, or on a new table if no argument is
+// given, and then returns that table.
+func (c *Converter) moduleTagTable(tag *lua.LTable) lua.LGFunction {
+	return func(L *lua.LState) int {
+		table := L.OptTable(1, L.NewTable())
+		if table.Metatable != lua.LNil && table.Metatable != c.arrayMetatable && table.Metatable != c.dictMetatable {
+			L.ArgError(1, "table already has a metatable")
+			return 0
+		}
+		table.Metatable = tag
+		L.Push(table)
+		return 1
+	}
+}
+
+// moduleTypedValue returns a Lua function that takes a single type argument
+// and returns the wrapped result of passing that type to the given function.
+func (c *Converter) moduleTypedValue(cons func(cty.Type) cty.Value) lua.LGFunction {
+	return func(L *lua.LState) int {
+		ty := c.checkType(L, 1)
+		L.Push(c.WrapCtyValue(cons(ty)))
+		return 1
+	}
+}
+
+func (c *Converter) moduleTuple(L *lua.LState) int {
+	vL := L.CheckAny(1)
+
+	var ty cty.Type
+	var err error
+	if table, isTable := vL.(*lua.LTable); isTable {
+		ty, err = c.impliedTupleType(&toCtyState{}, table, make(cty.Path, 0))
+	} else {
+		ty, err = c.ImpliedCtyType(vL)
+	}
+	if err != nil {
+		L.ArgError(1, err.Error())
+		return 0
+	}
+
+	v, err := c.ToCtyValue(vL, ty)
+	if err != nil {
+		L.ArgError(1, err.Error())
+		return 0
+	}
+	if !v.Type().IsTupleType() {
+		L.ArgError(1, "a tuple is required")
+		return 0
+	}
+	L.Push(c.WrapCtyValue(v))
+	return 1
+}
+
+func (c *Converter) moduleObject(L *lua.LState) int {
+	vL := L.CheckAny(1)
+
+	var ty cty.Type
+	var err error
+	if table, isTable := vL.(*lua.LTable); isTable {
+		ty, err = c.impliedObjectType(&toCtyState{}, table, make(cty.Path, 0))
+	} else {
+		ty, err = c.ImpliedCtyType(vL)
+	}
+	if err != nil {
+		L.ArgError(1, err.Error())
+		return 0
+	}
+
+	v, err := c.ToCtyValue(vL, ty)
+	if err != nil {
+		L.ArgError(1, err.Error())
+		return 0
+	}
+	if !v.Type().IsObjectType() {
+		L.ArgError(1, "an object is required")
+		return 0
+	}
+	L.Push(c.WrapCtyValue(v))
+	return 1
+}
+
+// moduleCollectionType returns a Lua function that takes a single type
+// argument and returns the collection type produced by the given function.
+func (c *Converter) moduleCollectionType(cons func(cty.Type) cty.Type) lua.LGFunction {
+	return func(L *lua.LState) int {
+		ety := c.checkType(L, 1)
+		L.Push(c.WrapCtyType(cons(ety)))
+		return 1
+	}
+}
+
+func (c *Converter) moduleObjectType(L *lua.LState) int {
+	table := L.CheckTable(1)
+	atys := make(map[string]cty.Type)
+	var err error
+	table.ForEach(func(key lua.LValue, value lua.LValue) {
+		if err != nil {
+			return
+		}
+		name, isStr := key.(lua.LString)
+		if !isStr {
+			err = fmt.Errorf("attribute names must be strings")
+			return
+		}
+		aty, tyErr := c.ToCtyType(value)
+		if tyErr != nil {
+			err = fmt.Errorf("invalid type for attribute %q: %s", string(name), tyErr)
+			return
+		}
+		atys[string(name)] = aty
+	})
+	if err != nil {
+		L.ArgError(1, err.Error())
+		return 0
+	}
+	L.Push(c.WrapCtyType(cty.Object(atys)))
+	return 1
+}
+
+func (c *Converter) moduleTupleType(L *lua.LState) int {
+	table := L.CheckTable(1)
+	l := table.Len()
+	etys := make([]cty.Type, l)
+	for i := range etys {
+		ety, err := c.ToCtyType(table.RawGetInt(i + 1))
+		if err != nil {
+			L.ArgError(1, fmt.Sprintf("invalid type for element %d: %s", i+1, err))
+			return 0
+		}
+		etys[i] = ety
+	}
+	if count := countTableKeys(table); count != l {
+		L.ArgError(1, "tuple element types must be given as a sequence")
+		return 0
+	}
+	L.Push(c.WrapCtyType(cty.Tuple(etys)))
+	return 1
+}
+
+// countTableKeys returns the total number of keys in the given table,
+// including both the array and hash parts.
+func countTableKeys(table *lua.LTable) int {
+	count := 0
+	table.ForEach(func(lua.LValue, lua.LValue) {
+		count++
+	})
+	return count
+}
+
+func (c *Converter) moduleParseType(L *lua.LState) int {
+	src := L.CheckString(1)
+	ty, err := ParseTypeExpr(src)
+	if err != nil {
+		L.ArgError(1, err.Error())
+		return 0
+	}
+	L.Push(c.WrapCtyType(ty))
+	return 1
+}
+
+func (c *Converter) moduleMethods(L *lua.LState) int {
+	v := c.checkValue(L, 1)
+	L.Push(c.wrapMethods(v))
+	return 1
+}
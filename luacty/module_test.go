@@ -0,0 +1,286 @@
+package luacty
+
+import (
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestConverterPreloadModule(t *testing.T) {
+	tests := map[string]struct {
+		Vals   map[string]cty.Value
+		Assert string
+	}{
+		"list": {
+			map[string]cty.Value{
+				"want": cty.ListVal([]cty.Value{
+					cty.StringVal("a"),
+					cty.StringVal("b"),
+				}),
+			},
+			`
+				result = cty.list{"a", "b"}
+				assert(result == want)
+			`,
+		},
+		"list (empty)": {
+			map[string]cty.Value{
+				"want": cty.ListValEmpty(cty.DynamicPseudoType),
+			},
+			`
+				result = cty.list{}
+				assert(result == want)
+			`,
+		},
+		"list (unified)": {
+			map[string]cty.Value{
+				"want": cty.ListVal([]cty.Value{
+					cty.StringVal("a"),
+					cty.StringVal("1"),
+				}),
+			},
+			`
+				result = cty.list{"a", 1}
+				assert(result == want)
+			`,
+		},
+		"set": {
+			map[string]cty.Value{
+				"want": cty.SetVal([]cty.Value{
+					cty.StringVal("a"),
+					cty.StringVal("b"),
+				}),
+			},
+			`
+				result = cty.set{"b", "a", "b"}
+				assert(result == want)
+			`,
+		},
+		"map": {
+			map[string]cty.Value{
+				"want": cty.MapVal(map[string]cty.Value{
+					"greeting": cty.StringVal("hello"),
+				}),
+			},
+			`
+				result = cty.map{greeting = "hello"}
+				assert(result == want)
+			`,
+		},
+		"tuple": {
+			map[string]cty.Value{
+				"want": cty.TupleVal([]cty.Value{
+					cty.StringVal("a"),
+					cty.NumberIntVal(1),
+					cty.True,
+				}),
+			},
+			`
+				result = cty.tuple{"a", 1, true}
+				assert(result == want)
+			`,
+		},
+		"object": {
+			map[string]cty.Value{
+				"want": cty.ObjectVal(map[string]cty.Value{
+					"name": cty.StringVal("web"),
+					"port": cty.NumberIntVal(80),
+					"tags": cty.ListVal([]cty.Value{cty.StringVal("a")}),
+				}),
+			},
+			`
+				result = cty.object{
+					name = "web",
+					port = 80,
+					tags = cty.list{"a"},
+				}
+				assert(result == want)
+			`,
+		},
+		"string": {
+			map[string]cty.Value{
+				"want": cty.StringVal("12"),
+			},
+			`
+				assert(cty.string("12") == want)
+				assert(cty.string(12) == want)
+			`,
+		},
+		"number": {
+			map[string]cty.Value{
+				"want": cty.NumberIntVal(12),
+			},
+			`
+				assert(cty.number(12) == want)
+				assert(cty.number("12") == want)
+			`,
+		},
+		"bool": {
+			map[string]cty.Value{
+				"want": cty.True,
+			},
+			`
+				assert(cty.bool(true) == want)
+				assert(cty.bool(false) ~= want)
+			`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L)
+			conv.PreloadModule("cty")
+			if err := L.DoString(`cty = require("cty")`); err != nil {
+				t.Fatalf("failed to load module: %s", err)
+			}
+			addTestFuncs(L, t)
+
+			for n, v := range test.Vals {
+				L.SetGlobal(n, conv.WrapCtyValue(v))
+			}
+
+			err := L.DoString(test.Assert)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestConverterPreloadModuleErrors(t *testing.T) {
+	tests := map[string]string{
+		"list (invalid)":      `cty.list{"a", {}}`,
+		"map (invalid)":       `cty.map{a = 1, b = {}}`,
+		"tuple (extra keys)":  `cty.tuple{"a", b = "c"}`,
+		"object from string":  `cty.object("hello")`,
+		"number (invalid)":    `cty.number("hello")`,
+		"string from table":   `cty.string({})`,
+		"function not values": `cty.object{f = function() end}`,
+	}
+
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L)
+			conv.PreloadModule("cty")
+			if err := L.DoString(`cty = require("cty")`); err != nil {
+				t.Fatalf("failed to load module: %s", err)
+			}
+
+			err := L.DoString(src)
+			if err == nil {
+				t.Errorf("call succeeded; want error")
+			}
+		})
+	}
+}
+
+func TestConverterModuleTableTags(t *testing.T) {
+	tests := map[string]struct {
+		Opts []ConverterOption
+		Src  string
+		Want cty.Value
+		Err  bool
+	}{
+		"empty array": {
+			nil,
+			`cty.array{}`,
+			cty.EmptyTupleVal,
+			false,
+		},
+		"empty array without argument": {
+			nil,
+			`cty.array()`,
+			cty.EmptyTupleVal,
+			false,
+		},
+		"empty array as list": {
+			[]ConverterOption{WithSequencesAsLists()},
+			`cty.array{}`,
+			cty.ListValEmpty(cty.DynamicPseudoType),
+			false,
+		},
+		"empty array overriding option": {
+			[]ConverterOption{WithEmptyTableType(cty.Map(cty.String))},
+			`{a = cty.array{}, b = {}}`,
+			cty.ObjectVal(map[string]cty.Value{
+				"a": cty.EmptyTupleVal,
+				"b": cty.MapValEmpty(cty.String),
+			}),
+			false,
+		},
+		"array": {
+			nil,
+			`cty.array{"a", true}`,
+			cty.TupleVal([]cty.Value{cty.StringVal("a"), cty.True}),
+			false,
+		},
+		"array with string keys": {
+			nil,
+			`cty.array{a = "b"}`,
+			cty.DynamicVal,
+			true, // a table tagged as an array must not have string keys
+		},
+		"empty dict": {
+			[]ConverterOption{WithEmptyTableType(cty.EmptyTuple)},
+			`cty.dict{}`,
+			cty.EmptyObjectVal,
+			false,
+		},
+		"dict": {
+			nil,
+			`cty.dict{a = "b"}`,
+			cty.ObjectVal(map[string]cty.Value{
+				"a": cty.StringVal("b"),
+			}),
+			false,
+		},
+		"dict with numeric keys": {
+			nil,
+			`cty.dict{"a"}`,
+			cty.DynamicVal,
+			true, // a table tagged as a dict must have only string keys
+		},
+		"retagged": {
+			nil,
+			`cty.dict(cty.array{})`,
+			cty.EmptyObjectVal,
+			false,
+		},
+		"foreign metatable": {
+			nil,
+			`cty.array(setmetatable({}, {}))`,
+			cty.DynamicVal,
+			true, // table already has a metatable
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L, test.Opts...)
+			conv.PreloadModule("cty")
+			if err := L.DoString(`cty = require("cty")`); err != nil {
+				t.Fatalf("failed to load module: %s", err)
+			}
+
+			err := L.DoString("v = " + test.Src)
+			if err == nil {
+				var got cty.Value
+				got, err = conv.ToCtyValue(L.GetGlobal("v"), cty.DynamicPseudoType)
+				if !got.RawEquals(test.Want) {
+					t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, test.Want)
+				}
+			}
+			if (err != nil) != test.Err {
+				if test.Err {
+					t.Errorf("conversion succeeded; want error")
+				} else {
+					t.Errorf("unexpected error: %s", err)
+				}
+			}
+		})
+	}
+}
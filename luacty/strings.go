@@ -0,0 +1,107 @@
+package luacty
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// StringPolicy selects how a converter deals with Lua strings that are not
+// valid UTF-8, which can be passed to WithStringPolicy.
+//
+// Lua strings are arbitrary sequences of bytes, whereas cty strings are
+// sequences of Unicode characters, and so a Lua string that contains binary
+// data cannot be represented faithfully as a cty string.
+type StringPolicy int
+
+const (
+	// StringsUnchecked passes the bytes of Lua strings through to cty
+	// unchanged, which is the default. cty does not expect invalid UTF-8
+	// and so the results of operations on such strings are unspecified.
+	StringsUnchecked StringPolicy = iota
+
+	// StringsRequireUTF8 causes conversion to fail with an error for a
+	// string that is not valid UTF-8.
+	StringsRequireUTF8
+
+	// StringsReplaceInvalid replaces each invalid sequence of bytes with
+	// the Unicode replacement character U+FFFD.
+	StringsReplaceInvalid
+
+	// StringsInvalidAsBase64 converts a string that is not valid UTF-8
+	// to a cty string containing the base64 encoding of its bytes. Valid
+	// strings are converted as normal, so the application must be able to
+	// tell from context whether a string might be base64-encoded.
+	StringsInvalidAsBase64
+
+	// StringsInvalidAsBytes converts a string that is not valid UTF-8 to a
+	// value of BytesType when a type is chosen automatically. Conversion of
+	// such a string to cty.String fails with an error, as for
+	// StringsRequireUTF8.
+	StringsInvalidAsBytes
+)
+
+// WithStringPolicy is a ConverterOption that selects how Lua strings that
+// are not valid UTF-8 are converted to cty values, as described for each of
+// the StringPolicy values. The default is StringsUnchecked.
+//
+// The policy applies to string values and map keys. Table keys that would
+// become object attribute names are always rejected if they are not valid
+// UTF-8, unless the policy is StringsUnchecked, because rewriting them would
+// produce attributes that don't correspond to the keys of the table.
+func WithStringPolicy(policy StringPolicy) ConverterOption {
+	return func(c *Converter) {
+		c.stringPolicy = policy
+	}
+}
+
+// BytesType is a cty capsule type representing an arbitrary sequence of
+// bytes, used for Lua strings that are not valid UTF-8 when a converter is
+// created with the StringsInvalidAsBytes policy.
+//
+// Any Lua string can be converted to BytesType by passing it as the target
+// type of ToCtyValue, and ToLuaValue converts a BytesType value back into a
+// Lua string containing the same bytes.
+var BytesType = cty.Capsule("bytes", reflect.TypeOf([]byte(nil)))
+
+// BytesVal returns a value of BytesType containing the given bytes.
+func BytesVal(b []byte) cty.Value {
+	return cty.CapsuleVal(BytesType, &b)
+}
+
+// AsBytes returns the bytes contained in the given value, which must be a
+// known, non-null value of BytesType.
+func AsBytes(v cty.Value) []byte {
+	return *(v.EncapsulatedValue().(*[]byte))
+}
+
+// stringVal converts the given Lua string to a cty string according to the
+// converter's string policy.
+func (c *Converter) stringVal(str lua.LString, path cty.Path) (cty.Value, error) {
+	s := string(str)
+	if c.stringPolicy == StringsUnchecked || utf8.ValidString(s) {
+		return cty.StringVal(s), nil
+	}
+
+	switch c.stringPolicy {
+	case StringsReplaceInvalid:
+		return cty.StringVal(strings.ToValidUTF8(s, "\uFFFD")), nil
+	case StringsInvalidAsBase64:
+		return cty.StringVal(base64.StdEncoding.EncodeToString([]byte(s))), nil
+	default:
+		return cty.DynamicVal, path.NewErrorf("string is not valid UTF-8")
+	}
+}
+
+// impliedStringType returns the type implied by the given Lua string, which
+// is cty.String unless the converter's string policy calls for BytesType.
+func (c *Converter) impliedStringType(str lua.LString) cty.Type {
+	if c.stringPolicy == StringsInvalidAsBytes && !utf8.ValidString(string(str)) {
+		return BytesType
+	}
+	return cty.String
+}
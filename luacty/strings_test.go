@@ -0,0 +1,251 @@
+package luacty
+
+import (
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestConverterStringPolicy(t *testing.T) {
+	tests := map[string]struct {
+		Policy  StringPolicy
+		Value   lua.LValue
+		Type    cty.Type
+		Want    cty.Value
+		WantErr string
+	}{
+		"unchecked": {
+			StringsUnchecked,
+			lua.LString("a\xffb"),
+			cty.String,
+			cty.StringVal("a\xffb"),
+			"",
+		},
+		"require valid": {
+			StringsRequireUTF8,
+			lua.LString("héllo"),
+			cty.String,
+			cty.StringVal("héllo"),
+			"",
+		},
+		"require invalid": {
+			StringsRequireUTF8,
+			lua.LString("a\xffb"),
+			cty.String,
+			cty.NilVal,
+			"string is not valid UTF-8",
+		},
+		"replace": {
+			StringsReplaceInvalid,
+			lua.LString("a\xff\xfeb"),
+			cty.String,
+			cty.StringVal("a\uFFFDb"),
+			"",
+		},
+		"base64 valid": {
+			StringsInvalidAsBase64,
+			lua.LString("hello"),
+			cty.String,
+			cty.StringVal("hello"),
+			"",
+		},
+		"base64 invalid": {
+			StringsInvalidAsBase64,
+			lua.LString("\x00\xff"),
+			cty.String,
+			cty.StringVal("AP8="),
+			"",
+		},
+		"bytes implied": {
+			StringsInvalidAsBytes,
+			lua.LString("\x00\xff"),
+			cty.DynamicPseudoType,
+			BytesVal([]byte("\x00\xff")),
+			"",
+		},
+		"bytes implied valid": {
+			StringsInvalidAsBytes,
+			lua.LString("hello"),
+			cty.DynamicPseudoType,
+			cty.StringVal("hello"),
+			"",
+		},
+		"bytes to string": {
+			StringsInvalidAsBytes,
+			lua.LString("\x00\xff"),
+			cty.String,
+			cty.NilVal,
+			"string is not valid UTF-8",
+		},
+		"bytes requested": {
+			StringsUnchecked,
+			lua.LString("hello"),
+			BytesType,
+			BytesVal([]byte("hello")),
+			"",
+		},
+		"bytes from number": {
+			StringsUnchecked,
+			lua.LNumber(1),
+			BytesType,
+			cty.NilVal,
+			"a string is required",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L, WithStringPolicy(test.Policy))
+
+			got, err := conv.ToCtyValue(test.Value, test.Type)
+			if test.WantErr != "" {
+				if err == nil {
+					t.Fatalf("conversion succeeded; want error")
+				}
+				if got := err.Error(); got != test.WantErr {
+					t.Errorf("wrong error\ngot:  %s\nwant: %s", got, test.WantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !got.Type().Equals(test.Want.Type()) {
+				t.Fatalf("wrong type\ngot:  %#v\nwant: %#v", got.Type(), test.Want.Type())
+			}
+			if got.Type().Equals(BytesType) {
+				if string(AsBytes(got)) != string(AsBytes(test.Want)) {
+					t.Errorf("wrong result\ngot:  %q\nwant: %q", AsBytes(got), AsBytes(test.Want))
+				}
+				return
+			}
+			if !got.RawEquals(test.Want) {
+				t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, test.Want)
+			}
+		})
+	}
+}
+
+func TestConverterStringPolicyNested(t *testing.T) {
+	badKeyType := cty.Object(map[string]cty.Type{
+		"ok\xff": cty.String,
+	})
+
+	tests := map[string]struct {
+		Policy   StringPolicy
+		Src      string
+		Type     cty.Type
+		WantPath cty.Path
+		WantErr  string
+	}{
+		"invalid attribute value": {
+			StringsRequireUTF8,
+			`v = {name = "ok", data = "\255"}`,
+			cty.DynamicPseudoType,
+			cty.GetAttrPath("data"),
+			"string is not valid UTF-8",
+		},
+		"invalid attribute name unchecked": {
+			StringsUnchecked,
+			`v = {["ok\255"] = "v"}`,
+			cty.DynamicPseudoType,
+			nil,
+			"",
+		},
+		"invalid attribute name with require": {
+			StringsRequireUTF8,
+			`v = {["ok\255"] = "v"}`,
+			cty.DynamicPseudoType,
+			nil,
+			`attribute name "ok\xff" is not valid UTF-8`,
+		},
+		"invalid attribute name with replace": {
+			StringsReplaceInvalid,
+			`v = {["ok\255"] = "v"}`,
+			cty.DynamicPseudoType,
+			nil,
+			`attribute name "ok\xff" is not valid UTF-8`,
+		},
+		"invalid attribute name with base64": {
+			StringsInvalidAsBase64,
+			`v = {["ok\255"] = "v"}`,
+			cty.DynamicPseudoType,
+			nil,
+			`attribute name "ok\xff" is not valid UTF-8`,
+		},
+		"invalid attribute name with bytes": {
+			StringsInvalidAsBytes,
+			`v = {["ok\255"] = "v"}`,
+			cty.DynamicPseudoType,
+			nil,
+			`attribute name "ok\xff" is not valid UTF-8`,
+		},
+		"invalid nested attribute name": {
+			StringsReplaceInvalid,
+			`v = {a = {["ok\255"] = "v"}}`,
+			cty.DynamicPseudoType,
+			cty.GetAttrPath("a"),
+			`attribute name "ok\xff" is not valid UTF-8`,
+		},
+		"invalid attribute name with object type": {
+			StringsReplaceInvalid,
+			`v = {["ok\255"] = "v"}`,
+			badKeyType,
+			nil,
+			`attribute name "ok\xff" is not valid UTF-8`,
+		},
+		"invalid attribute name with object type unchecked": {
+			StringsUnchecked,
+			`v = {["ok\255"] = "v"}`,
+			badKeyType,
+			nil,
+			"",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L, WithStringPolicy(test.Policy))
+			if err := L.DoString(test.Src); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			_, err := conv.ToCtyValue(L.GetGlobal("v"), test.Type)
+			if test.WantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("conversion succeeded; want error")
+			}
+			if got := err.Error(); got != test.WantErr {
+				t.Errorf("wrong error\ngot:  %s\nwant: %s", got, test.WantErr)
+			}
+			pathErr, ok := err.(cty.PathError)
+			if !ok {
+				t.Fatalf("error is %T; want cty.PathError", err)
+			}
+			if !pathErr.Path.Equals(test.WantPath) {
+				t.Errorf("wrong path\ngot:  %#v\nwant: %#v", pathErr.Path, test.WantPath)
+			}
+		})
+	}
+}
+
+func TestConverterToLuaValueBytes(t *testing.T) {
+	L := lua.NewState()
+	conv := NewConverter(L)
+
+	got, err := conv.ToLuaValue(BytesVal([]byte("\x00\xff")), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := lua.LString("\x00\xff"); got != want {
+		t.Errorf("wrong result\ngot:  %q\nwant: %q", got, want)
+	}
+}
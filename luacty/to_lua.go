@@ -1,7 +1,9 @@
 package luacty
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zclconf/go-cty/cty"
@@ -16,13 +18,245 @@ import (
 // semantics when used with other such wrapped values, but the result may
 // not integrate well with native Lua values. For example, a wrapped cty.String
 // value will not compare equal to any native Lua string.
+//
+// Wrapped values whose types do not accept string keys -- that is, all
+// types other than map and object types -- also expose methods to Lua,
+// such as v:is_known(), v:type() and v:convert(t). The methods of maps and
+// objects can be accessed using the "methods" function from the Lua module
+// registered by PreloadModule.
+//
+// Values of capsule types registered with RegisterCapsuleType are instead
+// wrapped using the metatable registered for their type.
 func (c *Converter) WrapCtyValue(val cty.Value) lua.LValue {
+	if ud := c.wrapCapsule(val); ud != nil {
+		return ud
+	}
 	ret := c.lstate.NewUserData()
 	ret.Value = val
 	ret.Metatable = c.metatable
 	return ret
 }
 
+// WrapCtyValueNative is a variant of WrapCtyValue that returns a native Lua
+// boolean, number or string when given a known, non-null, unmarked value of
+// a primitive type, and a wrapped value as would be returned by WrapCtyValue
+// for all other values.
+//
+// Native values integrate better with Lua code: in particular, a wrapped
+// cty.False is a userdata value and is thus considered "true" by Lua
+// conditionals, whereas a native Lua false is not. The tradeoff is that the
+// native values follow Lua semantics rather than cty semantics, and so
+// operations on them may produce different results than the equivalent
+// operations on wrapped values.
+//
+// Numbers that cannot be represented exactly as a Lua number are also
+// returned wrapped, so that no precision is lost. Values of LuaValueType are
+// returned as the Lua value they carry.
+//
+// Converting the result back to cty using ToCtyValue with the value's
+// original type produces a value equal to the one given.
+func (c *Converter) WrapCtyValueNative(val cty.Value) lua.LValue {
+	if !val.IsKnown() || val.IsNull() || val.IsMarked() {
+		return c.WrapCtyValue(val)
+	}
+
+	switch val.Type() {
+	case cty.String:
+		return lua.LString(val.AsString())
+	case cty.Number:
+		f, acc := val.AsBigFloat().Float64()
+		if acc != big.Exact {
+			return c.WrapCtyValue(val)
+		}
+		return lua.LNumber(f)
+	case cty.Bool:
+		return lua.LBool(val.True())
+	case LuaValueType:
+		return AsLuaValue(val)
+	default:
+		return c.WrapCtyValue(val)
+	}
+}
+
+// wrapResult wraps a value produced by an operation on wrapped values,
+// using either WrapCtyValue or WrapCtyValueNative depending on how the
+// converter is configured.
+func (c *Converter) wrapResult(val cty.Value) lua.LValue {
+	if c.nativePrimitives {
+		return c.WrapCtyValueNative(val)
+	}
+	return c.WrapCtyValue(val)
+}
+
+// ToLuaOptions customizes the behavior of ToLuaValue.
+//
+// The zero value of ToLuaOptions selects the default behaviors described
+// for each field.
+type ToLuaOptions struct {
+	// Sets selects how cty set values are represented in Lua. The default
+	// is SetsAsSequences.
+	Sets SetRepresentation
+
+	// Unknown, if not nil, is used in place of any unknown value that is
+	// encountered during conversion. If it is nil, unknown values cause
+	// conversion to fail with an error.
+	Unknown lua.LValue
+}
+
+// SetRepresentation is an enumeration of the possible ways that ToLuaValue
+// can represent cty set values as Lua tables.
+type SetRepresentation int
+
+const (
+	// SetsAsSequences represents each set as a table whose keys are the
+	// integers 1 through n, with the set elements as values in the order
+	// that cty would iterate over them.
+	SetsAsSequences SetRepresentation = iota
+
+	// SetsAsLookups represents each set as a table whose keys are the set
+	// elements and whose values are all true, so that membership can be
+	// tested by indexing the table. Only sets whose element type is
+	// primitive can be represented in this way.
+	SetsAsLookups
+)
+
+// ToLuaValue converts the given cty value into an equivalent native Lua
+// value, rather than wrapping it as WrapCtyValue does.
+//
+// Primitive values become native Lua strings, numbers and booleans, and null
+// values of any type become nil. Lists and tuples become tables whose keys
+// are the integers 1 through n, following Lua conventions rather than cty
+// conventions. Maps and objects become tables with string keys. Sets are
+// represented as described for the Sets field of the given options, which
+// may be nil to select the default options.
+//
+// The resulting tables for lists, tuples, maps and objects are tagged in the
+// same way as by the "array" and "dict" functions in the Lua module, so that
+// converting them back to cty with an automatically-chosen type produces
+// a similar type even if they are empty.
+//
+// Because Lua tables cannot contain nil values, null elements of lists and
+// tuples appear as "holes" in the resulting table, and null map elements and
+// object attributes are omitted altogether.
+//
+// Numbers are rounded to the nearest Lua number, unless the converter was
+// created with WithExactNumbers, in which case numbers that cannot be
+// represented exactly produce an error.
+//
+// Values of BytesType become Lua strings containing the same bytes, values
+// of LuaValueType become the Lua value they carry, and values of capsule
+// types registered with RegisterCapsuleType become userdata with the
+// registered metatable, as for WrapCtyValue.
+//
+// Unknown values, marked values and other capsule values cannot be
+// represented as native Lua values. Unknown values can be replaced with a
+// placeholder by setting the Unknown field of the given options, but
+// conversion fails with an error for the others.
+//
+// Where possible, errors are cty.PathError values describing the location of
+// the error within the given value.
+func (c *Converter) ToLuaValue(val cty.Value, opts *ToLuaOptions) (lua.LValue, error) {
+	if opts == nil {
+		opts = &ToLuaOptions{}
+	}
+	path := make(cty.Path, 0)
+	return c.toLuaValue(val, opts, path)
+}
+
+func (c *Converter) toLuaValue(val cty.Value, opts *ToLuaOptions, path cty.Path) (lua.LValue, error) {
+	if val.IsMarked() {
+		return lua.LNil, path.NewErrorf("marked values cannot be converted to native Lua values")
+	}
+	if !val.IsKnown() {
+		if opts.Unknown == nil {
+			return lua.LNil, path.NewErrorf("unknown values cannot be converted to native Lua values")
+		}
+		return opts.Unknown, nil
+	}
+	if val.IsNull() {
+		return lua.LNil, nil
+	}
+
+	ty := val.Type()
+	switch {
+	case ty == cty.String:
+		return lua.LString(val.AsString()), nil
+	case ty == cty.Number:
+		f, acc := val.AsBigFloat().Float64()
+		if acc != big.Exact && c.exactNumbers {
+			return lua.LNil, path.NewErrorf("number %s cannot be represented exactly as a Lua number", val.AsBigFloat().Text('f', -1))
+		}
+		return lua.LNumber(f), nil
+	case ty == cty.Bool:
+		return lua.LBool(val.True()), nil
+	case ty.Equals(BytesType):
+		return lua.LString(AsBytes(val)), nil
+	case ty.Equals(LuaValueType):
+		return AsLuaValue(val), nil
+	case ty.IsCapsuleType() && c.capsuleMetatables[ty] != nil:
+		return c.wrapCapsule(val), nil
+	case ty.IsListType() || ty.IsTupleType() || (ty.IsSetType() && opts.Sets == SetsAsSequences):
+		table := c.lstate.NewTable()
+		i := 0
+		for it := val.ElementIterator(); it.Next(); i++ {
+			_, ev := it.Element()
+			path := append(path, cty.IndexStep{
+				Key: cty.NumberIntVal(int64(i)),
+			})
+			evL, err := c.toLuaValue(ev, opts, path)
+			if err != nil {
+				return lua.LNil, err
+			}
+			table.RawSetInt(i+1, evL) // lua tables are 1-indexed
+		}
+		table.Metatable = c.arrayMetatable
+		return table, nil
+	case ty.IsSetType():
+		if !ty.ElementType().IsPrimitiveType() {
+			return lua.LNil, path.NewErrorf("a set of %s cannot be represented as a lookup table", ty.ElementType().FriendlyName())
+		}
+		table := c.lstate.NewTable()
+		for it := val.ElementIterator(); it.Next(); {
+			_, ev := it.Element()
+			path := append(path, cty.IndexStep{
+				Key: ev,
+			})
+			evL, err := c.toLuaValue(ev, opts, path)
+			if err != nil {
+				return lua.LNil, err
+			}
+			if evL == lua.LNil {
+				// A null element cannot be used as a table key, so we
+				// just skip it.
+				continue
+			}
+			table.RawSet(evL, lua.LTrue)
+		}
+		return table, nil
+	case ty.IsMapType() || ty.IsObjectType():
+		table := c.lstate.NewTable()
+		for it := val.ElementIterator(); it.Next(); {
+			k, ev := it.Element()
+			var step cty.PathStep
+			if ty.IsObjectType() {
+				step = cty.GetAttrStep{Name: k.AsString()}
+			} else {
+				step = cty.IndexStep{Key: k}
+			}
+			path := append(path, step)
+			evL, err := c.toLuaValue(ev, opts, path)
+			if err != nil {
+				return lua.LNil, err
+			}
+			table.RawSetString(k.AsString(), evL)
+		}
+		table.Metatable = c.dictMetatable
+		return table, nil
+	default:
+		return lua.LNil, path.NewErrorf("%s values cannot be converted to native Lua values", ty.FriendlyName())
+	}
+}
+
 func (c *Converter) ctyMetatable() *lua.LTable {
 	L := c.lstate
 	table := L.NewTable()
@@ -67,7 +301,9 @@ func (c *Converter) ctyEq(L *lua.LState) int {
 		return 1
 	}
 
-	result := a.Value.(cty.Value).Equals(b.Value.(cty.Value))
+	// The result must be a native Lua boolean, which cannot carry marks,
+	// and so any marks on the result are lost.
+	result, _ := a.Value.(cty.Value).Equals(b.Value.(cty.Value)).Unmark()
 	if result.IsKnown() {
 		L.Push(lua.LBool(result.True()))
 	} else {
@@ -86,19 +322,19 @@ func (c *Converter) ctyArithmetic(op func(a, b cty.Value) (cty.Value, error)) lu
 
 		a, err := c.ToCtyValue(aL, cty.Number)
 		if err != nil {
-			L.Error(lua.LString(err.Error()), 1)
+			c.raiseError(L, "conversion", err)
 		}
 		b, err := c.ToCtyValue(bL, cty.Number)
 		if err != nil {
-			L.Error(lua.LString(err.Error()), 1)
+			c.raiseError(L, "conversion", err)
 		}
 
 		result, err := op(a, b)
 		if err != nil {
-			L.Error(lua.LString(err.Error()), 1)
+			c.raiseError(L, "operation", err)
 		}
 
-		L.Push(c.WrapCtyValue(result))
+		L.Push(c.wrapResult(result))
 		return 1
 	}
 }
@@ -108,15 +344,15 @@ func (c *Converter) ctyNegate(L *lua.LState) int {
 
 	v, err := c.ToCtyValue(vL, cty.Number)
 	if err != nil {
-		L.Error(lua.LString(err.Error()), 1)
+		c.raiseError(L, "conversion", err)
 	}
 
 	result, err := stdlib.Negate(v)
 	if err != nil {
-		L.Error(lua.LString(err.Error()), 1)
+		c.raiseError(L, "operation", err)
 	}
 
-	L.Push(c.WrapCtyValue(result))
+	L.Push(c.wrapResult(result))
 	return 1
 }
 
@@ -126,20 +362,26 @@ func (c *Converter) ctyConcat(L *lua.LState) int {
 
 	a, err := c.ToCtyValue(aL, cty.String)
 	if err != nil {
-		L.Error(lua.LString(err.Error()), 1)
+		c.raiseError(L, "conversion", err)
 	}
 	b, err := c.ToCtyValue(bL, cty.String)
 	if err != nil {
-		L.Error(lua.LString(err.Error()), 1)
+		c.raiseError(L, "conversion", err)
 	}
 
+	a, aMarks := a.Unmark()
+	b, bMarks := b.Unmark()
+	if a.IsNull() || b.IsNull() {
+		c.raiseErrorf(L, "operation", "can't concatenate a null value")
+		return 0
+	}
 	if !(a.IsKnown() && b.IsKnown()) {
-		L.Push(c.WrapCtyValue(cty.UnknownVal(cty.String)))
+		L.Push(c.wrapResult(cty.UnknownVal(cty.String).WithMarks(aMarks, bMarks)))
 		return 1
 	}
 
-	result := cty.StringVal(a.AsString() + b.AsString())
-	L.Push(c.WrapCtyValue(result))
+	result := cty.StringVal(a.AsString()+b.AsString()).WithMarks(aMarks, bMarks)
+	L.Push(c.wrapResult(result))
 	return 1
 }
 
@@ -148,27 +390,27 @@ func (c *Converter) ctyLength(L *lua.LState) int {
 
 	v, err := c.ToCtyValue(vL, cty.DynamicPseudoType)
 	if err != nil {
-		L.Error(lua.LString(err.Error()), 1)
+		c.raiseError(L, "conversion", err)
 	}
 
 	if v.Type() == cty.String {
 		result, err := stdlib.Strlen(v)
 		if err != nil {
-			L.Error(lua.LString(err.Error()), 1)
+			c.raiseError(L, "operation", err)
 			return 0
 		}
 
-		L.Push(c.WrapCtyValue(result))
+		L.Push(c.wrapResult(result))
 		return 1
 	}
 
 	result, err := stdlib.Length(v)
 	if err != nil {
-		L.Error(lua.LString(err.Error()), 1)
+		c.raiseError(L, "operation", err)
 		return 0
 	}
 
-	L.Push(c.WrapCtyValue(result))
+	L.Push(c.wrapResult(result))
 	return 1
 }
 
@@ -178,20 +420,21 @@ func (c *Converter) ctyLessThan(L *lua.LState) int {
 
 	a, err := c.ToCtyValue(aL, cty.Number)
 	if err != nil {
-		L.Error(lua.LString(err.Error()), 1)
+		c.raiseError(L, "conversion", err)
 		return 0
 	}
 	b, err := c.ToCtyValue(bL, cty.Number)
 	if err != nil {
-		L.Error(lua.LString(err.Error()), 1)
+		c.raiseError(L, "conversion", err)
 		return 0
 	}
 
 	result, err := stdlib.LessThan(a, b)
 	if err != nil {
-		L.Error(lua.LString(err.Error()), 1)
+		c.raiseError(L, "operation", err)
 		return 0
 	}
+	result, _ = result.Unmark() // a Lua bool can't carry marks
 
 	if !result.IsKnown() {
 		L.Push(lua.LBool(false)) // can't represent unknown as Lua bool
@@ -208,32 +451,56 @@ func (c *Converter) ctyIndex(L *lua.LState) int {
 
 	coll, err := c.ToCtyValue(collL, cty.DynamicPseudoType)
 	if err != nil {
-		L.Error(lua.LString(err.Error()), 1)
+		c.raiseError(L, "conversion", err)
 		return 0
 	}
 
 	collTy := coll.Type()
+
+	// Values whose types do not accept string keys expose their methods
+	// via indexing instead, so that scripts can use the colon syntax.
+	if name, isStr := keyL.(lua.LString); isStr && !(collTy.IsMapType() || collTy.IsObjectType()) {
+		if method := c.methods.RawGetString(string(name)); method != lua.LNil {
+			L.Push(method)
+			return 1
+		}
+	}
+
+	// Marks on the collection and the key apply to every result, including
+	// the unknown results we return when we can't look up the element.
+	coll, collMarks := coll.Unmark()
+
 	var keyType cty.Type
 	switch {
 	case collTy.IsMapType() || collTy.IsObjectType():
 		keyType = cty.String
 	case collTy.IsListType() || collTy.IsTupleType():
 		keyType = cty.Number
+	case collTy == cty.DynamicPseudoType && !coll.IsKnown():
+		// A value of unknown type might turn out to be indexable once
+		// known, so we can't say anything about the result.
+		L.Push(c.wrapResult(cty.DynamicVal.WithMarks(collMarks)))
+		return 1
 	default:
-		L.Error(lua.LString(fmt.Sprintf("can't index value of type %s", collTy.FriendlyName())), 1)
+		c.raiseErrorf(L, "index", "can't index value of type %s", collTy.FriendlyName())
+	}
+	if coll.IsNull() {
+		c.raiseErrorf(L, "index", "can't index a null value")
+		return 0
 	}
 
 	key, err := c.ToCtyValue(keyL, keyType)
 	if err != nil {
-		L.Error(lua.LString(fmt.Sprintf("invalid key for %s: %s", collTy.FriendlyName(), err)), 1)
+		c.raiseErrorf(L, "index", "invalid key for %s: %s", collTy.FriendlyName(), err)
 		return 0
 	}
+	key, keyMarks := key.Unmark()
 
 	switch {
 	case collTy.IsListType() || collTy.IsMapType() || collTy.IsTupleType():
 		hasIndex := coll.HasIndex(key)
 		if !hasIndex.IsKnown() {
-			L.Push(c.WrapCtyValue(cty.DynamicVal))
+			L.Push(c.wrapResult(cty.DynamicVal.WithMarks(collMarks, keyMarks)))
 			return 1
 		}
 
@@ -244,12 +511,12 @@ func (c *Converter) ctyIndex(L *lua.LState) int {
 			return 1
 		}
 
-		result := coll.Index(key)
-		L.Push(c.WrapCtyValue(result))
+		result := coll.Index(key).WithMarks(collMarks, keyMarks)
+		L.Push(c.wrapResult(result))
 		return 1
 	case collTy.IsObjectType():
 		if !key.IsKnown() {
-			L.Push(c.WrapCtyValue(cty.DynamicVal))
+			L.Push(c.wrapResult(cty.DynamicVal.WithMarks(collMarks, keyMarks)))
 			return 1
 		}
 
@@ -262,8 +529,8 @@ func (c *Converter) ctyIndex(L *lua.LState) int {
 			return 1
 		}
 
-		result := coll.GetAttr(attrName)
-		L.Push(c.WrapCtyValue(result))
+		result := coll.GetAttr(attrName).WithMarks(collMarks, keyMarks)
+		L.Push(c.wrapResult(result))
 		return 1
 	default:
 		// should never happen
@@ -273,7 +540,7 @@ func (c *Converter) ctyIndex(L *lua.LState) int {
 
 func (c *Converter) ctyInvalidOp(msg string) lua.LGFunction {
 	return func(L *lua.LState) int {
-		L.Error(lua.LString(msg), 1)
+		c.raiseError(L, "operation", errors.New(msg))
 		return 0
 	}
 }
@@ -338,6 +338,328 @@ func TestConverterWrapCtyValue(t *testing.T) {
 	}
 }
 
+func TestConverterWrapCtyValueNative(t *testing.T) {
+	tests := map[string]struct {
+		Val        cty.Value
+		WantNative lua.LValue // nil if the result should be wrapped
+	}{
+		"string": {
+			cty.StringVal("hello"),
+			lua.LString("hello"),
+		},
+		"number": {
+			cty.NumberIntVal(12),
+			lua.LNumber(12),
+		},
+		"number (fractional)": {
+			cty.NumberFloatVal(1.5),
+			lua.LNumber(1.5),
+		},
+		"number (inexact)": {
+			cty.MustParseNumberVal("12345678901234567891"),
+			nil,
+		},
+		"true": {
+			cty.True,
+			lua.LTrue,
+		},
+		"false": {
+			cty.False,
+			lua.LFalse,
+		},
+		"null string": {
+			cty.NullVal(cty.String),
+			nil,
+		},
+		"unknown bool": {
+			cty.UnknownVal(cty.Bool),
+			nil,
+		},
+		"marked string": {
+			cty.StringVal("secret").Mark("sensitive"),
+			nil,
+		},
+		"list": {
+			cty.ListVal([]cty.Value{cty.True}),
+			nil,
+		},
+		"object": {
+			cty.EmptyObjectVal,
+			nil,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L)
+
+			got := conv.WrapCtyValueNative(test.Val)
+			if test.WantNative != nil {
+				if got != test.WantNative {
+					t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, test.WantNative)
+				}
+			} else {
+				if ud, ok := got.(*lua.LUserData); !ok || ud.Metatable != conv.metatable {
+					t.Errorf("result is not a wrapped value: %#v", got)
+				}
+			}
+
+			back, err := conv.ToCtyValue(got, test.Val.Type())
+			if err != nil {
+				t.Fatalf("unexpected error converting back to cty: %s", err)
+			}
+			if !back.RawEquals(test.Val) {
+				t.Errorf("value did not round-trip\ngot:  %#v\nwant: %#v", back, test.Val)
+			}
+		})
+	}
+}
+
+func TestConverterWithNativePrimitives(t *testing.T) {
+	tests := map[string]struct {
+		Vals   map[string]cty.Value
+		Assert string
+	}{
+		"bool attribute": {
+			map[string]cty.Value{
+				"a": cty.ObjectVal(map[string]cty.Value{
+					"enabled":  cty.False,
+					"disabled": cty.True,
+				}),
+			},
+			`
+				assert(a.enabled == false)
+				assert(a.disabled == true)
+				local branch = "none"
+				if a.enabled then
+					branch = "enabled"
+				end
+				assert(branch == "none")
+			`,
+		},
+		"string element": {
+			map[string]cty.Value{
+				"a": cty.ListVal([]cty.Value{cty.StringVal("hello")}),
+			},
+			`
+				assert(a[0] == "hello")
+				assert(type(a[0]) == "string")
+			`,
+		},
+		"arithmetic": {
+			map[string]cty.Value{
+				"a": cty.NumberIntVal(2),
+			},
+			`
+				assert(a + 3 == 5)
+			`,
+		},
+		"unknown stays wrapped": {
+			map[string]cty.Value{
+				"a": cty.ObjectVal(map[string]cty.Value{
+					"enabled": cty.UnknownVal(cty.Bool),
+				}),
+			},
+			`
+				assert(type(a.enabled) == "userdata")
+			`,
+		},
+		"collection stays wrapped": {
+			map[string]cty.Value{
+				"a": cty.ObjectVal(map[string]cty.Value{
+					"names": cty.ListValEmpty(cty.String),
+				}),
+			},
+			`
+				assert(type(a.names) == "userdata")
+			`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L, WithNativePrimitives())
+			addTestFuncs(L, t)
+
+			for n, v := range test.Vals {
+				L.SetGlobal(n, conv.WrapCtyValue(v))
+			}
+
+			err := L.DoString(test.Assert)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestConverterToLuaValue(t *testing.T) {
+	tests := map[string]struct {
+		Val    cty.Value
+		Opts   *ToLuaOptions
+		Assert string
+		Err    bool
+	}{
+		"string": {
+			cty.StringVal("hello"),
+			nil,
+			`assert(result == "hello")`,
+			false,
+		},
+		"number": {
+			cty.NumberFloatVal(1.5),
+			nil,
+			`assert(result == 1.5)`,
+			false,
+		},
+		"bool": {
+			cty.False,
+			nil,
+			`assert(result == false)`,
+			false,
+		},
+		"null": {
+			cty.NullVal(cty.List(cty.String)),
+			nil,
+			`assert(result == nil)`,
+			false,
+		},
+		"list": {
+			cty.ListVal([]cty.Value{
+				cty.StringVal("a"),
+				cty.StringVal("b"),
+			}),
+			nil,
+			`
+				assert(#result == 2)
+				assert(result[1] == "a")
+				assert(result[2] == "b")
+			`,
+			false,
+		},
+		"tuple": {
+			cty.TupleVal([]cty.Value{
+				cty.StringVal("a"),
+				cty.True,
+			}),
+			nil,
+			`
+				assert(result[1] == "a")
+				assert(result[2] == true)
+			`,
+			false,
+		},
+		"map": {
+			cty.MapVal(map[string]cty.Value{
+				"greeting": cty.StringVal("hello"),
+			}),
+			nil,
+			`assert(result.greeting == "hello")`,
+			false,
+		},
+		"nested object": {
+			cty.ObjectVal(map[string]cty.Value{
+				"servers": cty.ListVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{
+						"port": cty.NumberIntVal(80),
+					}),
+				}),
+				"absent": cty.NullVal(cty.String),
+			}),
+			nil,
+			`
+				assert(result.servers[1].port == 80)
+				assert(result.absent == nil)
+			`,
+			false,
+		},
+		"set as sequence": {
+			cty.SetVal([]cty.Value{
+				cty.StringVal("b"),
+				cty.StringVal("a"),
+			}),
+			nil,
+			`
+				assert(#result == 2)
+				assert(result[1] == "a")
+				assert(result[2] == "b")
+			`,
+			false,
+		},
+		"set as lookup": {
+			cty.SetVal([]cty.Value{
+				cty.StringVal("b"),
+				cty.StringVal("a"),
+			}),
+			&ToLuaOptions{Sets: SetsAsLookups},
+			`
+				assert(result.a == true)
+				assert(result.b == true)
+				assert(result.c == nil)
+			`,
+			false,
+		},
+		"set of objects as lookup": {
+			cty.SetVal([]cty.Value{
+				cty.EmptyObjectVal,
+			}),
+			&ToLuaOptions{Sets: SetsAsLookups},
+			``,
+			true, // a set of object cannot be represented as a lookup table
+		},
+		"unknown": {
+			cty.ObjectVal(map[string]cty.Value{
+				"id": cty.UnknownVal(cty.String),
+			}),
+			nil,
+			``,
+			true, // unknown values cannot be converted to native Lua values
+		},
+		"unknown with placeholder": {
+			cty.ObjectVal(map[string]cty.Value{
+				"id": cty.UnknownVal(cty.String),
+			}),
+			&ToLuaOptions{Unknown: lua.LString("(known after apply)")},
+			`assert(result.id == "(known after apply)")`,
+			false,
+		},
+		"marked": {
+			cty.StringVal("secret").Mark("sensitive"),
+			nil,
+			``,
+			true, // marked values cannot be converted to native Lua values
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			addTestFuncs(L, t)
+			conv := NewConverter(L)
+
+			got, err := conv.ToLuaValue(test.Val, test.Opts)
+			if (err != nil) != test.Err {
+				if test.Err {
+					t.Errorf("conversion succeeded; want error")
+				} else {
+					t.Errorf("unexpected error: %s", err)
+				}
+			}
+			if err != nil {
+				return
+			}
+
+			L.SetGlobal("result", got)
+			err = L.DoString(test.Assert)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
 func addTestFuncs(L *lua.LState, t *testing.T) {
 	print := L.NewFunction(func(L *lua.LState) int {
 		val := L.CheckString(1)
@@ -375,3 +697,37 @@ func addTestFuncs(L *lua.LState, t *testing.T) {
 	L.SetGlobal("require", require)
 	L.SetGlobal("dump", dump)
 }
+
+func TestConverterToLuaValueRoundTrip(t *testing.T) {
+	L := lua.NewState()
+	conv := NewConverter(L)
+
+	want := cty.ObjectVal(map[string]cty.Value{
+		"names": cty.EmptyTupleVal,
+		"attrs": cty.EmptyObjectVal,
+		"pairs": cty.TupleVal([]cty.Value{
+			cty.StringVal("a"),
+			cty.NumberIntVal(1),
+		}),
+	})
+	v := cty.ObjectVal(map[string]cty.Value{
+		"names": cty.ListValEmpty(cty.String),
+		"attrs": cty.MapValEmpty(cty.String),
+		"pairs": cty.TupleVal([]cty.Value{
+			cty.StringVal("a"),
+			cty.NumberIntVal(1),
+		}),
+	})
+
+	vL, err := conv.ToLuaValue(v, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got, err := conv.ToCtyValue(vL, cty.DynamicPseudoType)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !got.RawEquals(want) {
+		t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, want)
+	}
+}
@@ -0,0 +1,315 @@
+package luacty
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// ParseTypeExpr parses the given string as a type expression using the
+// same syntax as HCL's type constraints, such as:
+//
+//     list(object({name = string, port = number}))
+//
+// The primitive type keywords are string, number and bool, and the keyword
+// any represents cty.DynamicPseudoType. The type constructors are list, set,
+// map, object and tuple. Attribute types in an object type may be wrapped in
+// optional(...) to produce an object type with optional attributes, as
+// would be created by cty.ObjectWithOptionalAttrs.
+//
+// Error messages are written with a Lua developer as the audience, and so
+// will not include Go-specific implementation details.
+func ParseTypeExpr(src string) (cty.Type, error) {
+	p := &typeExprParser{src: src}
+	ty, err := p.parseType()
+	if err != nil {
+		return cty.NilType, err
+	}
+	p.skipSpace()
+	if !p.atEnd() {
+		return cty.NilType, p.errorf("unexpected extra characters after type expression")
+	}
+	return ty, nil
+}
+
+// typeExprParser is a simple recursive descent parser for the type
+// expression syntax accepted by ParseTypeExpr.
+type typeExprParser struct {
+	src string
+	pos int
+}
+
+func (p *typeExprParser) parseType() (cty.Type, error) {
+	p.skipSpace()
+	start := p.pos
+	keyword := p.readIdent()
+	if keyword == "" {
+		return cty.NilType, p.errorf("a type keyword is required")
+	}
+
+	switch keyword {
+	case "string":
+		return cty.String, nil
+	case "number":
+		return cty.Number, nil
+	case "bool":
+		return cty.Bool, nil
+	case "any":
+		return cty.DynamicPseudoType, nil
+	case "list", "set", "map":
+		if err := p.expect('(', fmt.Sprintf("the %s type constructor requires one argument specifying the element type", keyword)); err != nil {
+			return cty.NilType, err
+		}
+		ety, err := p.parseType()
+		if err != nil {
+			return cty.NilType, err
+		}
+		if err := p.expect(')', fmt.Sprintf("the %s type constructor requires exactly one argument", keyword)); err != nil {
+			return cty.NilType, err
+		}
+		switch keyword {
+		case "list":
+			return cty.List(ety), nil
+		case "set":
+			return cty.Set(ety), nil
+		default:
+			return cty.Map(ety), nil
+		}
+	case "object":
+		if err := p.expect('(', "the object type constructor requires one argument specifying the attribute types"); err != nil {
+			return cty.NilType, err
+		}
+		ty, err := p.parseObjectAttrs()
+		if err != nil {
+			return cty.NilType, err
+		}
+		if err := p.expect(')', "the object type constructor requires exactly one argument"); err != nil {
+			return cty.NilType, err
+		}
+		return ty, nil
+	case "tuple":
+		if err := p.expect('(', "the tuple type constructor requires one argument specifying the element types"); err != nil {
+			return cty.NilType, err
+		}
+		ty, err := p.parseTupleElems()
+		if err != nil {
+			return cty.NilType, err
+		}
+		if err := p.expect(')', "the tuple type constructor requires exactly one argument"); err != nil {
+			return cty.NilType, err
+		}
+		return ty, nil
+	case "optional":
+		p.pos = start
+		return cty.NilType, p.errorf("optional is allowed only for object attribute types")
+	default:
+		p.pos = start
+		return cty.NilType, p.errorf("the keyword %q is not a valid type specification", keyword)
+	}
+}
+
+func (p *typeExprParser) parseObjectAttrs() (cty.Type, error) {
+	if err := p.expect('{', "the object type constructor requires an object of attribute types"); err != nil {
+		return cty.NilType, err
+	}
+
+	atys := make(map[string]cty.Type)
+	var optional []string
+	for {
+		p.skipSpace()
+		if p.consume('}') {
+			break
+		}
+
+		start := p.pos
+		var name string
+		if p.peek() == '"' {
+			var err error
+			name, err = p.readQuoted()
+			if err != nil {
+				return cty.NilType, err
+			}
+		} else {
+			name = p.readIdent()
+		}
+		if name == "" {
+			return cty.NilType, p.errorf("an attribute name is required")
+		}
+		if _, exists := atys[name]; exists {
+			p.pos = start
+			return cty.NilType, p.errorf("duplicate attribute name %q", name)
+		}
+
+		p.skipSpace()
+		if !(p.consume('=') || p.consume(':')) {
+			return cty.NilType, p.errorf("an equals sign is required after attribute name %q", name)
+		}
+
+		aty, isOptional, err := p.parseAttrType()
+		if err != nil {
+			return cty.NilType, err
+		}
+		atys[name] = aty
+		if isOptional {
+			optional = append(optional, name)
+		}
+
+		end := p.pos
+		p.skipSpace()
+		if !p.consume(',') && p.peek() != '}' {
+			if p.atEnd() {
+				return cty.NilType, p.errorf("the object type is missing its closing brace")
+			}
+			if !p.sawNewline(end) {
+				return cty.NilType, p.errorf("attribute definitions must be separated by commas or newlines")
+			}
+		}
+	}
+
+	if len(optional) > 0 {
+		return cty.ObjectWithOptionalAttrs(atys, optional), nil
+	}
+	return cty.Object(atys), nil
+}
+
+// parseAttrType parses the type of an object attribute, which may
+// additionally be wrapped in the optional(...) modifier.
+func (p *typeExprParser) parseAttrType() (cty.Type, bool, error) {
+	p.skipSpace()
+	start := p.pos
+	if p.readIdent() != "optional" {
+		p.pos = start
+		ty, err := p.parseType()
+		return ty, false, err
+	}
+
+	if err := p.expect('(', "the optional modifier requires one argument specifying the attribute type"); err != nil {
+		return cty.NilType, false, err
+	}
+	ty, err := p.parseType()
+	if err != nil {
+		return cty.NilType, false, err
+	}
+	if err := p.expect(')', "the optional modifier requires exactly one argument"); err != nil {
+		return cty.NilType, false, err
+	}
+	return ty, true, nil
+}
+
+func (p *typeExprParser) parseTupleElems() (cty.Type, error) {
+	if err := p.expect('[', "the tuple type constructor requires a list of element types"); err != nil {
+		return cty.NilType, err
+	}
+
+	var etys []cty.Type
+	for {
+		p.skipSpace()
+		if p.consume(']') {
+			break
+		}
+
+		ety, err := p.parseType()
+		if err != nil {
+			return cty.NilType, err
+		}
+		etys = append(etys, ety)
+
+		p.skipSpace()
+		if !p.consume(',') && p.peek() != ']' {
+			if p.atEnd() {
+				return cty.NilType, p.errorf("the tuple element types are missing their closing bracket")
+			}
+			return cty.NilType, p.errorf("tuple element types must be separated by commas")
+		}
+	}
+
+	return cty.Tuple(etys), nil
+}
+
+func (p *typeExprParser) expect(r rune, msg string) error {
+	p.skipSpace()
+	if !p.consume(r) {
+		return p.errorf("%s", msg)
+	}
+	return nil
+}
+
+func (p *typeExprParser) consume(r rune) bool {
+	if p.peek() == r {
+		p.pos += utf8.RuneLen(r)
+		return true
+	}
+	return false
+}
+
+func (p *typeExprParser) peek() rune {
+	if p.atEnd() {
+		return utf8.RuneError
+	}
+	r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+	return r
+}
+
+func (p *typeExprParser) atEnd() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *typeExprParser) skipSpace() {
+	for !p.atEnd() {
+		r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		if !unicode.IsSpace(r) {
+			return
+		}
+		p.pos += size
+	}
+}
+
+// sawNewline returns true if there is a newline character between the
+// given offset and the current position.
+func (p *typeExprParser) sawNewline(since int) bool {
+	for _, r := range p.src[since:p.pos] {
+		if r == '\n' {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *typeExprParser) readIdent() string {
+	start := p.pos
+	for !p.atEnd() {
+		r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		if !(r == '_' || r == '-' || unicode.IsLetter(r) || (p.pos > start && unicode.IsDigit(r))) {
+			break
+		}
+		p.pos += size
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *typeExprParser) readQuoted() (string, error) {
+	start := p.pos
+	p.pos++ // opening quote
+	for !p.atEnd() {
+		r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.pos += size
+		switch r {
+		case '"':
+			return p.src[start+1 : p.pos-1], nil
+		case '\\', '\n':
+			p.pos -= size
+			return "", p.errorf("attribute names may not contain escape sequences or newlines")
+		}
+	}
+	p.pos = start
+	return "", p.errorf("unterminated attribute name")
+}
+
+func (p *typeExprParser) errorf(format string, args ...interface{}) error {
+	// Lua developers count characters rather than bytes, so the position
+	// is reported in characters even when the source isn't ASCII.
+	char := utf8.RuneCountInString(p.src[:p.pos]) + 1
+	return fmt.Errorf("invalid type expression at character %d: %s", char, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,214 @@
+package luacty
+
+import (
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestParseTypeExpr(t *testing.T) {
+	tests := map[string]struct {
+		Src  string
+		Want cty.Type
+		Err  bool
+	}{
+		"string": {
+			`string`,
+			cty.String,
+			false,
+		},
+		"number": {
+			`number`,
+			cty.Number,
+			false,
+		},
+		"bool": {
+			`bool`,
+			cty.Bool,
+			false,
+		},
+		"any": {
+			`any`,
+			cty.DynamicPseudoType,
+			false,
+		},
+		"list of string": {
+			`list(string)`,
+			cty.List(cty.String),
+			false,
+		},
+		"set of number": {
+			` set( number ) `,
+			cty.Set(cty.Number),
+			false,
+		},
+		"map of list of any": {
+			`map(list(any))`,
+			cty.Map(cty.List(cty.DynamicPseudoType)),
+			false,
+		},
+		"object": {
+			`list(object({name=string, port=number}))`,
+			cty.List(cty.Object(map[string]cty.Type{
+				"name": cty.String,
+				"port": cty.Number,
+			})),
+			false,
+		},
+		"object (empty)": {
+			`object({})`,
+			cty.EmptyObject,
+			false,
+		},
+		"object (quoted names and colons)": {
+			`object({"name": string, "a b": bool,})`,
+			cty.Object(map[string]cty.Type{
+				"name": cty.String,
+				"a b":  cty.Bool,
+			}),
+			false,
+		},
+		"object (newline separated)": {
+			"object({\n  name = string\n  port = number\n})",
+			cty.Object(map[string]cty.Type{
+				"name": cty.String,
+				"port": cty.Number,
+			}),
+			false,
+		},
+		"object (optional attributes)": {
+			`object({name=string, port=optional(number)})`,
+			cty.ObjectWithOptionalAttrs(map[string]cty.Type{
+				"name": cty.String,
+				"port": cty.Number,
+			}, []string{"port"}),
+			false,
+		},
+		"tuple": {
+			`tuple([string, bool])`,
+			cty.Tuple([]cty.Type{cty.String, cty.Bool}),
+			false,
+		},
+		"tuple (empty)": {
+			`tuple([])`,
+			cty.EmptyTuple,
+			false,
+		},
+
+		"empty": {
+			``,
+			cty.NilType,
+			true, // a type keyword is required
+		},
+		"unknown keyword": {
+			`strin`,
+			cty.NilType,
+			true, // the keyword "strin" is not a valid type specification
+		},
+		"list without argument": {
+			`list`,
+			cty.NilType,
+			true, // the list type constructor requires one argument
+		},
+		"map with too many arguments": {
+			`map(string, number)`,
+			cty.NilType,
+			true, // the map type constructor requires exactly one argument
+		},
+		"object without braces": {
+			`object(string)`,
+			cty.NilType,
+			true, // the object type constructor requires an object of attribute types
+		},
+		"object missing separator": {
+			`object({a=string b=string})`,
+			cty.NilType,
+			true, // attribute definitions must be separated by commas or newlines
+		},
+		"object duplicate attribute": {
+			`object({a=string, a=number})`,
+			cty.NilType,
+			true, // duplicate attribute name "a"
+		},
+		"object unterminated": {
+			`object({a=string`,
+			cty.NilType,
+			true, // the object type is missing its closing brace
+		},
+		"optional outside object": {
+			`optional(string)`,
+			cty.NilType,
+			true, // optional is allowed only for object attribute types
+		},
+		"tuple without brackets": {
+			`tuple(string)`,
+			cty.NilType,
+			true, // the tuple type constructor requires a list of element types
+		},
+		"extra characters": {
+			`string string`,
+			cty.NilType,
+			true, // unexpected extra characters after type expression
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseTypeExpr(test.Src)
+			if (err != nil) != test.Err {
+				if test.Err {
+					t.Errorf("parsing succeeded; want error")
+				} else {
+					t.Errorf("unexpected error: %s", err)
+				}
+			}
+
+			if test.Want == cty.NilType {
+				if got != cty.NilType {
+					t.Errorf("wrong result\ninput: %s\ngot:   %#v\nwant:  cty.NilType", test.Src, got)
+				}
+				return
+			}
+			if got == cty.NilType || !got.Equals(test.Want) {
+				t.Errorf("wrong result\ninput: %s\ngot:   %#v\nwant:  %#v", test.Src, got, test.Want)
+			}
+		})
+	}
+}
+
+func TestParseTypeExprErrorPosition(t *testing.T) {
+	// Positions count characters rather than bytes.
+	_, err := ParseTypeExpr(`object({"naïve"=string, "ü"=bogus})`)
+	if err == nil {
+		t.Fatalf("parsing succeeded; want error")
+	}
+	want := `invalid type expression at character 29: the keyword "bogus" is not a valid type specification`
+	if got := err.Error(); got != want {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestConverterParseTypeExprLua(t *testing.T) {
+	L := lua.NewState()
+	conv := NewConverter(L)
+	conv.PreloadModule("cty")
+	if err := L.DoString(`cty = require("cty")`); err != nil {
+		t.Fatalf("failed to load module: %s", err)
+	}
+	addTestFuncs(L, t)
+
+	err := L.DoString(`
+		local ty = cty.parse_type("list(object({name=string}))")
+		assert(ty == cty.List(cty.Object{name = cty.String}))
+		assert(cty.List("string") == cty.List(cty.String))
+	`)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	err = L.DoString(`cty.parse_type("list(")`)
+	if err == nil {
+		t.Errorf("parsing invalid type succeeded; want error")
+	}
+}
@@ -0,0 +1,142 @@
+package luacty
+
+import (
+	"fmt"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// WrapCtyType takes a cty Type and returns a Lua value (of type UserData)
+// that represents that type, so that it can be passed to Lua functions that
+// expect a type, such as the type constructors in the module registered by
+// PreloadModule.
+//
+// Wrapped types compare equal to one another using the Lua == operator if
+// the underlying types are equal, and convert to a string using the type's
+// "friendly name".
+func (c *Converter) WrapCtyType(ty cty.Type) lua.LValue {
+	ret := c.lstate.NewUserData()
+	ret.Value = ty
+	ret.Metatable = c.typeMetatable
+	return ret
+}
+
+// ToCtyType returns the cty Type represented by the given Lua value, which
+// must either be a userdata value produced by WrapCtyType or a string
+// containing a type expression as accepted by ParseTypeExpr.
+//
+// Error messages are written with a Lua developer as the audience, and so
+// will not include Go-specific implementation details.
+func (c *Converter) ToCtyType(val lua.LValue) (cty.Type, error) {
+	switch tv := val.(type) {
+	case *lua.LUserData:
+		if ty, isType := tv.Value.(cty.Type); isType {
+			return ty, nil
+		}
+	case lua.LString:
+		return ParseTypeExpr(string(tv))
+	}
+	return cty.NilType, fmt.Errorf("a type is required")
+}
+
+// checkType is a helper for Lua function implementations that expect
+// a type at the given stack index. It raises an OperationError of kind
+// "argument" if the value at that index is not a type.
+func (c *Converter) checkType(L *lua.LState, n int) cty.Type {
+	ty, err := c.ToCtyType(L.CheckAny(n))
+	if err != nil {
+		c.raiseArgError(L, "argument", n, err)
+	}
+	return ty
+}
+
+func (c *Converter) ctyTypeMetatable() *lua.LTable {
+	L := c.lstate
+	table := L.NewTable()
+
+	methods := L.NewTable()
+	L.SetFuncs(methods, map[string]lua.LGFunction{
+		"is_primitive":   c.ctyTypePredicate(cty.Type.IsPrimitiveType),
+		"is_list":        c.ctyTypePredicate(cty.Type.IsListType),
+		"is_map":         c.ctyTypePredicate(cty.Type.IsMapType),
+		"is_set":         c.ctyTypePredicate(cty.Type.IsSetType),
+		"is_object":      c.ctyTypePredicate(cty.Type.IsObjectType),
+		"is_tuple":       c.ctyTypePredicate(cty.Type.IsTupleType),
+		"is_collection":  c.ctyTypePredicate(cty.Type.IsCollectionType),
+		"is_capsule":     c.ctyTypePredicate(cty.Type.IsCapsuleType),
+		"is_dynamic":     c.ctyTypePredicate(func(ty cty.Type) bool { return ty == cty.DynamicPseudoType }),
+		"has_attribute":  c.ctyTypeHasAttribute,
+		"attribute_type": c.ctyTypeAttributeType,
+		"element_type":   c.ctyTypeElementType,
+		"friendly_name":  c.ctyTypeToString,
+	})
+
+	table.RawSet(lua.LString("__eq"), L.NewFunction(c.ctyTypeEq))
+	table.RawSet(lua.LString("__tostring"), L.NewFunction(c.ctyTypeToString))
+	table.RawSet(lua.LString("__index"), methods)
+	table.RawSet(lua.LString("__newindex"), L.NewFunction(c.ctyInvalidOp("type is immutable")))
+	table.RawSet(lua.LString("__call"), L.NewFunction(c.ctyInvalidOp("type cannot be called")))
+
+	return table
+}
+
+func (c *Converter) ctyTypeEq(L *lua.LState) int {
+	// As with ctyEq, we must be defensive here because we might be
+	// comparing with userdata created by other packages.
+	a, aErr := c.ToCtyType(L.CheckAny(1))
+	b, bErr := c.ToCtyType(L.CheckAny(2))
+	if aErr != nil || bErr != nil {
+		L.Push(lua.LBool(false))
+		return 1
+	}
+
+	L.Push(lua.LBool(a.Equals(b)))
+	return 1
+}
+
+func (c *Converter) ctyTypeToString(L *lua.LState) int {
+	ty := c.checkType(L, 1)
+	L.Push(lua.LString(ty.FriendlyName()))
+	return 1
+}
+
+func (c *Converter) ctyTypePredicate(pred func(cty.Type) bool) lua.LGFunction {
+	return func(L *lua.LState) int {
+		ty := c.checkType(L, 1)
+		L.Push(lua.LBool(pred(ty)))
+		return 1
+	}
+}
+
+func (c *Converter) ctyTypeHasAttribute(L *lua.LState) int {
+	ty := c.checkType(L, 1)
+	name := L.CheckString(2)
+	L.Push(lua.LBool(ty.IsObjectType() && ty.HasAttribute(name)))
+	return 1
+}
+
+func (c *Converter) ctyTypeAttributeType(L *lua.LState) int {
+	ty := c.checkType(L, 1)
+	name := L.CheckString(2)
+	if !ty.IsObjectType() {
+		L.ArgError(1, fmt.Sprintf("%s has no attributes", ty.FriendlyName()))
+		return 0
+	}
+	if !ty.HasAttribute(name) {
+		L.Push(lua.LNil)
+		return 1
+	}
+	L.Push(c.WrapCtyType(ty.AttributeType(name)))
+	return 1
+}
+
+func (c *Converter) ctyTypeElementType(L *lua.LState) int {
+	ty := c.checkType(L, 1)
+	if !ty.IsCollectionType() {
+		L.ArgError(1, fmt.Sprintf("%s has no element type", ty.FriendlyName()))
+		return 0
+	}
+	L.Push(c.WrapCtyType(ty.ElementType()))
+	return 1
+}
@@ -0,0 +1,155 @@
+package luacty
+
+import (
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestConverterWrapCtyType(t *testing.T) {
+	tests := map[string]struct {
+		Types  map[string]cty.Type
+		Assert string
+	}{
+		"primitive constants": {
+			map[string]cty.Type{
+				"str": cty.String,
+				"num": cty.Number,
+				"bl":  cty.Bool,
+				"dyn": cty.DynamicPseudoType,
+			},
+			`
+				assert(cty.String == str)
+				assert(cty.Number == num)
+				assert(cty.Bool == bl)
+				assert(cty.DynamicPseudoType == dyn)
+				assert(cty.String ~= num)
+			`,
+		},
+		"collection types": {
+			map[string]cty.Type{
+				"l": cty.List(cty.String),
+				"m": cty.Map(cty.Number),
+				"s": cty.Set(cty.Bool),
+			},
+			`
+				assert(cty.List(cty.String) == l)
+				assert(cty.Map(cty.Number) == m)
+				assert(cty.Set(cty.Bool) == s)
+				assert(cty.List(cty.Number) ~= l)
+				assert(l:element_type() == cty.String)
+			`,
+		},
+		"object type": {
+			map[string]cty.Type{
+				"want": cty.Object(map[string]cty.Type{
+					"name": cty.String,
+					"port": cty.Number,
+				}),
+			},
+			`
+				result = cty.Object{name = cty.String, port = cty.Number}
+				assert(result == want)
+				assert(result:has_attribute("name"))
+				assert(not result:has_attribute("other"))
+				assert(result:attribute_type("port") == cty.Number)
+				assert(result:attribute_type("other") == nil)
+			`,
+		},
+		"tuple type": {
+			map[string]cty.Type{
+				"want": cty.Tuple([]cty.Type{cty.String, cty.Bool}),
+			},
+			`
+				result = cty.Tuple{cty.String, cty.Bool}
+				assert(result == want)
+			`,
+		},
+		"tostring": {
+			map[string]cty.Type{
+				"l": cty.List(cty.String),
+			},
+			`
+				assert(tostring(l) == "list of string")
+				assert(tostring(cty.DynamicPseudoType) == "dynamic")
+				assert(l:friendly_name() == "list of string")
+			`,
+		},
+		"predicates": {
+			map[string]cty.Type{
+				"l": cty.List(cty.String),
+				"m": cty.Map(cty.String),
+				"s": cty.Set(cty.String),
+				"o": cty.EmptyObject,
+				"t": cty.EmptyTuple,
+			},
+			`
+				assert(cty.String:is_primitive())
+				assert(not l:is_primitive())
+				assert(l:is_list() and l:is_collection())
+				assert(m:is_map() and m:is_collection())
+				assert(s:is_set() and s:is_collection())
+				assert(o:is_object() and not o:is_collection())
+				assert(t:is_tuple() and not t:is_list())
+				assert(cty.DynamicPseudoType:is_dynamic())
+				assert(not cty.String:is_dynamic())
+			`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			conv := NewConverter(L)
+			conv.PreloadModule("cty")
+			if err := L.DoString(`cty = require("cty")`); err != nil {
+				t.Fatalf("failed to load module: %s", err)
+			}
+			addTestFuncs(L, t)
+
+			for n, ty := range test.Types {
+				L.SetGlobal(n, conv.WrapCtyType(ty))
+			}
+
+			err := L.DoString(test.Assert)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestConverterToCtyType(t *testing.T) {
+	L := lua.NewState()
+	conv := NewConverter(L)
+
+	got, err := conv.ToCtyType(conv.WrapCtyType(cty.List(cty.String)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !got.Equals(cty.List(cty.String)) {
+		t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, cty.List(cty.String))
+	}
+
+	_, err = conv.ToCtyType(conv.WrapCtyValue(cty.StringVal("hello")))
+	if err == nil {
+		t.Errorf("conversion of value succeeded; want error")
+	}
+	_, err = conv.ToCtyType(lua.LNumber(1))
+	if err == nil {
+		t.Errorf("conversion of number succeeded; want error")
+	}
+
+	got, err = conv.ToCtyType(lua.LString("map(bool)"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !got.Equals(cty.Map(cty.Bool)) {
+		t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, cty.Map(cty.Bool))
+	}
+	_, err = conv.ToCtyType(lua.LString("map"))
+	if err == nil {
+		t.Errorf("conversion of invalid type expression succeeded; want error")
+	}
+}